@@ -0,0 +1,301 @@
+package gormutil
+
+import (
+	"container/list"
+	"fmt"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/avakarev/go-util/timeutil"
+)
+
+// Cache defines cache backend used by read-through model cache
+type Cache interface {
+	// Get returns cached value by given key
+	Get(key string) (any, bool)
+	// Set caches given value by given key
+	Set(key string, value any)
+	// Delete removes value by given key
+	Delete(key string)
+	// DeletePrefix removes all values whose keys start with given prefix
+	DeletePrefix(prefix string)
+}
+
+// LRUConfig defines LRU cache settings
+type LRUConfig struct {
+	// Size defines max number of cached values, defaults to 1024
+	Size int
+	// TTL defines how long values are cached, zero means forever
+	TTL time.Duration
+	// Clock defines time source, defaults to wall clock
+	Clock timeutil.Clock
+}
+
+type lruEntry struct {
+	key     string
+	value   any
+	expires time.Time
+}
+
+// LRUCache implements in-memory least recently used cache with ttl
+type LRUCache struct {
+	mu     sync.Mutex
+	config LRUConfig
+	items  map[string]*list.Element
+	order  *list.List
+}
+
+func (c *LRUCache) remove(el *list.Element) {
+	c.order.Remove(el)
+	delete(c.items, el.Value.(*lruEntry).key)
+}
+
+// Get returns cached value by given key
+func (c *LRUCache) Get(key string) (any, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.items[key]
+	if !ok {
+		return nil, false
+	}
+	e := el.Value.(*lruEntry)
+	if !e.expires.IsZero() && !c.config.Clock.Now().Before(e.expires) {
+		c.remove(el)
+		return nil, false
+	}
+	c.order.MoveToFront(el)
+	return e.value, true
+}
+
+// Set caches given value by given key, evicting least recently used value if cache is full
+func (c *LRUCache) Set(key string, value any) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e := &lruEntry{key: key, value: value}
+	if c.config.TTL > 0 {
+		e.expires = c.config.Clock.Now().Add(c.config.TTL)
+	}
+	if el, ok := c.items[key]; ok {
+		el.Value = e
+		c.order.MoveToFront(el)
+		return
+	}
+	c.items[key] = c.order.PushFront(e)
+	if c.order.Len() > c.config.Size {
+		c.remove(c.order.Back())
+	}
+}
+
+// Delete removes value by given key
+func (c *LRUCache) Delete(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.items[key]; ok {
+		c.remove(el)
+	}
+}
+
+// DeletePrefix removes all values whose keys start with given prefix
+func (c *LRUCache) DeletePrefix(prefix string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for key, el := range c.items {
+		if strings.HasPrefix(key, prefix) {
+			c.remove(el)
+		}
+	}
+}
+
+// Len returns number of cached values, including expired ones
+func (c *LRUCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.order.Len()
+}
+
+// NewLRUCache returns new LRU cache value
+func NewLRUCache(config LRUConfig) *LRUCache {
+	if config.Size <= 0 {
+		config.Size = 1024
+	}
+	if config.Clock == nil {
+		config.Clock = timeutil.NewClock()
+	}
+	return &LRUCache{
+		config: config,
+		items:  make(map[string]*list.Element),
+		order:  list.New(),
+	}
+}
+
+// CacheStats defines model cache statistics
+type CacheStats struct {
+	Hits          uint64 `json:"hits"`
+	Misses        uint64 `json:"misses"`
+	Invalidations uint64 `json:"invalidations"`
+}
+
+// HitRatio returns ratio of hits to all lookups
+func (s CacheStats) HitRatio() float64 {
+	if total := s.Hits + s.Misses; total > 0 {
+		return float64(s.Hits) / float64(total)
+	}
+	return 0
+}
+
+// modelCache tracks stats and per table generations of the cache backend
+type modelCache struct {
+	backend       Cache
+	mu            sync.Mutex
+	gens          map[string]uint64
+	hits          atomic.Uint64
+	misses        atomic.Uint64
+	invalidations atomic.Uint64
+}
+
+func newModelCache(c Cache) *modelCache {
+	return &modelCache{backend: c, gens: make(map[string]uint64)}
+}
+
+func (mc *modelCache) get(key string) (any, bool) {
+	v, ok := mc.backend.Get(key)
+	if ok {
+		mc.hits.Add(1)
+	} else {
+		mc.misses.Add(1)
+	}
+	return v, ok
+}
+
+func (mc *modelCache) gen(table string) uint64 {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+	return mc.gens[table]
+}
+
+// set caches value unless table was invalidated since the lookup started,
+// so that value read before concurrent write doesn't outlive the write
+func (mc *modelCache) set(table string, gen uint64, key string, value any) {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+	if mc.gens[table] == gen {
+		mc.backend.Set(key, value)
+	}
+}
+
+func (mc *modelCache) invalidate(hook *Hook) {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+	mc.gens[hook.Table]++
+	mc.invalidations.Add(1)
+	if id, ok := modelID(hook.Model); ok {
+		mc.backend.Delete(cacheKeyID(hook.Table, id))
+		mc.backend.DeletePrefix(hook.Table + ":q:")
+		return
+	}
+	mc.backend.DeletePrefix(hook.Table + ":")
+}
+
+func modelID(model any) (any, bool) {
+	source := reflect.Indirect(reflect.ValueOf(model))
+	if source.Kind() != reflect.Struct {
+		return nil, false
+	}
+	f := source.FieldByName("ID")
+	if !f.IsValid() || f.IsZero() {
+		return nil, false
+	}
+	return f.Interface(), true
+}
+
+func cacheKeyID(table string, id any) string {
+	return fmt.Sprintf("%s:id:%v", table, id)
+}
+
+func cacheKeyQuery(table string, kind string, cond any, args []any) string {
+	return fmt.Sprintf("%s:q:%s:%v:%v", table, kind, cond, args)
+}
+
+// CacheStats returns model cache statistics
+func (db *DB) CacheStats() CacheStats {
+	if db.cache == nil {
+		return CacheStats{}
+	}
+	return CacheStats{
+		Hits:          db.cache.hits.Load(),
+		Misses:        db.cache.misses.Load(),
+		Invalidations: db.cache.invalidations.Load(),
+	}
+}
+
+// FirstByID returns row with given primary key, reading through cache if it's enabled
+func FirstByID[T any](db *DB, id any) *T {
+	pk := "id"
+	if sch, err := db.Schema(new(T)); err == nil && sch.PrioritizedPrimaryField != nil {
+		pk = sch.PrioritizedPrimaryField.DBName
+	}
+	query := func() *T {
+		return First[T](db.Conn().Where(db.quote(pk)+" = ?", id))
+	}
+	if db.cache == nil {
+		return query()
+	}
+
+	table := TableName(new(T))
+	key := cacheKeyID(table, id)
+	if v, ok := db.cache.get(key); ok {
+		model := *v.(*T)
+		return &model
+	}
+	gen := db.cache.gen(table)
+	model := query()
+	if model != nil {
+		cp := *model
+		db.cache.set(table, gen, key, &cp)
+	}
+	return model
+}
+
+// CachedFirst returns first row matching given conditions, reading through cache if it's enabled
+func CachedFirst[T any](db *DB, cond any, args ...any) *T {
+	if db.cache == nil {
+		return First[T](db.Conn().Where(cond, args...))
+	}
+
+	table := TableName(new(T))
+	key := cacheKeyQuery(table, "first", cond, args)
+	if v, ok := db.cache.get(key); ok {
+		model := *v.(*T)
+		return &model
+	}
+	gen := db.cache.gen(table)
+	model := First[T](db.Conn().Where(cond, args...))
+	if model != nil {
+		cp := *model
+		db.cache.set(table, gen, key, &cp)
+	}
+	return model
+}
+
+// CachedFind returns all rows matching given conditions, reading through cache if it's enabled
+func CachedFind[T any](db *DB, cond any, args ...any) []T {
+	if db.cache == nil {
+		return Find[T](db.Conn().Where(cond, args...))
+	}
+
+	table := TableName(new(T))
+	key := cacheKeyQuery(table, "find", cond, args)
+	if v, ok := db.cache.get(key); ok {
+		return slices.Clone(v.([]T))
+	}
+	gen := db.cache.gen(table)
+	models := Find[T](db.Conn().Where(cond, args...))
+	if models != nil {
+		db.cache.set(table, gen, key, slices.Clone(models))
+	}
+	return models
+}
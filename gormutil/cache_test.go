@@ -0,0 +1,156 @@
+package gormutil_test
+
+import (
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+
+	"github.com/avakarev/go-util/testutil"
+	"github.com/avakarev/go-util/timeutil"
+
+	"github.com/avakarev/go-util/gormutil"
+)
+
+func TestLRUCacheEviction(t *testing.T) {
+	c := gormutil.NewLRUCache(gormutil.LRUConfig{Size: 2})
+	c.Set("foo", 1)
+	c.Set("bar", 2)
+	_, ok := c.Get("foo") // makes "bar" least recently used
+	testutil.Diff(true, ok, t)
+	c.Set("baz", 3)
+
+	_, ok = c.Get("bar")
+	testutil.Diff(false, ok, t)
+	v, ok := c.Get("foo")
+	testutil.Diff(true, ok, t)
+	testutil.Diff(1, v, t)
+	testutil.Diff(2, c.Len(), t)
+}
+
+func TestLRUCacheTTL(t *testing.T) {
+	clock := timeutil.NewMock()
+	c := gormutil.NewLRUCache(gormutil.LRUConfig{TTL: time.Minute, Clock: clock})
+	c.Set("foo", 1)
+
+	clock.Add(59 * time.Second)
+	_, ok := c.Get("foo")
+	testutil.Diff(true, ok, t)
+
+	clock.Add(time.Second)
+	_, ok = c.Get("foo")
+	testutil.Diff(false, ok, t)
+	testutil.Diff(0, c.Len(), t)
+}
+
+func TestLRUCacheDeletePrefix(t *testing.T) {
+	c := gormutil.NewLRUCache(gormutil.LRUConfig{})
+	c.Set("users:id:1", 1)
+	c.Set("users:q:first", 2)
+	c.Set("posts:id:1", 3)
+	c.DeletePrefix("users:")
+	testutil.Diff(1, c.Len(), t)
+	_, ok := c.Get("posts:id:1")
+	testutil.Diff(true, ok, t)
+}
+
+func TestCacheStatsHitRatio(t *testing.T) {
+	testutil.Diff(float64(0), gormutil.CacheStats{}.HitRatio(), t)
+	testutil.Diff(0.75, gormutil.CacheStats{Hits: 3, Misses: 1}.HitRatio(), t)
+}
+
+func TestCachedReads(t *testing.T) {
+	db := openDB(t, gormutil.WithCache(gormutil.NewLRUCache(gormutil.LRUConfig{})))
+	testutil.MustNoErr(db.Conn().AutoMigrate(&article{}), t)
+	a := newArticle(t, db, "one", "")
+	titles := func(models []article) []string {
+		list := make([]string, 0, len(models))
+		for _, m := range models {
+			list = append(list, m.Title)
+		}
+		return list
+	}
+
+	m := gormutil.FirstByID[article](db, a.ID)
+	testutil.Diff("one", m.Title, t)
+	m.Title = "changed by caller"
+	testutil.Diff("one", gormutil.FirstByID[article](db, a.ID).Title, t)
+	testutil.Diff("one", gormutil.CachedFirst[article](db, "title = ?", "one").Title, t)
+	testutil.Diff("one", gormutil.CachedFirst[article](db, "title = ?", "one").Title, t)
+	testutil.Diff([]string{"one"}, titles(gormutil.CachedFind[article](db, "title = ?", "one")), t)
+	testutil.Diff([]string{"one"}, titles(gormutil.CachedFind[article](db, "title = ?", "one")), t)
+	testutil.Diff(gormutil.CacheStats{Hits: 3, Misses: 3, Invalidations: 1}, db.CacheStats(), t)
+
+	// writes bypassing hooks aren't seen
+	testutil.MustNoErr(db.Conn().Model(a).Update("title", "raw").Error, t)
+	testutil.Diff("one", gormutil.FirstByID[article](db, a.ID).Title, t)
+
+	a.Title = "two"
+	testutil.MustNoErr(db.Update(a), t)
+	testutil.Diff("two", gormutil.FirstByID[article](db, a.ID).Title, t)
+	testutil.Diff([]string{}, titles(gormutil.CachedFind[article](db, "title = ?", "one")), t)
+	testutil.Diff([]string{"two"}, titles(gormutil.CachedFind[article](db, "title = ?", "two")), t)
+
+	newArticle(t, db, "two", "")
+	testutil.Diff([]string{"two", "two"}, titles(gormutil.CachedFind[article](db, "title = ?", "two")), t)
+
+	testutil.MustNoErr(db.Delete(a), t)
+	testutil.Diff(true, gormutil.FirstByID[article](db, a.ID) == nil, t)
+	testutil.Diff(uint64(4), db.CacheStats().Invalidations, t)
+}
+
+func TestCachedReadRacingWrite(t *testing.T) {
+	db := openDB(t, gormutil.WithCache(gormutil.NewLRUCache(gormutil.LRUConfig{})))
+	testutil.MustNoErr(db.Conn().AutoMigrate(&article{}), t)
+	a := newArticle(t, db, "one", "")
+
+	// update lands while the row is being read
+	racing := true
+	testutil.MustNoErr(db.Conn().Callback().Query().After("gorm:query").Register("test:racing_write", func(tx *gorm.DB) {
+		if racing {
+			racing = false
+			a.Title = "two"
+			testutil.MustNoErr(db.Update(a), t)
+		}
+	}), t)
+
+	testutil.Diff("one", gormutil.FirstByID[article](db, a.ID).Title, t)
+	// stale row isn't cached
+	testutil.Diff("two", gormutil.FirstByID[article](db, a.ID).Title, t)
+	testutil.Diff("two", gormutil.FirstByID[article](db, a.ID).Title, t)
+	testutil.Diff(gormutil.CacheStats{Hits: 1, Misses: 2, Invalidations: 2}, db.CacheStats(), t)
+}
+
+func TestCachedReadsTransaction(t *testing.T) {
+	db := openDB(t, gormutil.WithCache(gormutil.NewLRUCache(gormutil.LRUConfig{})))
+	testutil.MustNoErr(db.Conn().AutoMigrate(&article{}), t)
+	a := newArticle(t, db, "one", "")
+
+	tx := db.Begin()
+	a.Title = "two"
+	testutil.MustNoErr(tx.Update(a), t)
+	// concurrent reader caches committed row while transaction is pending
+	testutil.Diff("one", gormutil.FirstByID[article](db, a.ID).Title, t)
+	testutil.Diff(uint64(1), db.CacheStats().Invalidations, t)
+	testutil.MustNoErr(tx.Commit(), t)
+	testutil.Diff("two", gormutil.FirstByID[article](db, a.ID).Title, t)
+	testutil.Diff(uint64(2), db.CacheStats().Invalidations, t)
+
+	// rolled back write doesn't invalidate anything
+	tx = db.Begin()
+	a.Title = "three"
+	testutil.MustNoErr(tx.Update(a), t)
+	tx.Rollback()
+	testutil.Diff("two", gormutil.FirstByID[article](db, a.ID).Title, t)
+	testutil.Diff(gormutil.CacheStats{Hits: 1, Misses: 2, Invalidations: 2}, db.CacheStats(), t)
+}
+
+func TestCachedReadsDisabled(t *testing.T) {
+	db := openDB(t)
+	testutil.MustNoErr(db.Conn().AutoMigrate(&article{}), t)
+	a := newArticle(t, db, "one", "")
+	testutil.Diff("one", gormutil.FirstByID[article](db, a.ID).Title, t)
+	testutil.Diff("one", gormutil.CachedFirst[article](db, "title = ?", "one").Title, t)
+	testutil.Diff(1, len(gormutil.CachedFind[article](db, "title = ?", "one")), t)
+	testutil.Diff(gormutil.CacheStats{}, db.CacheStats(), t)
+}
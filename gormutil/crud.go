@@ -126,7 +126,11 @@ func (db *DB) Update(model any, names ...string) error {
 	}
 
 	if len(names) == 0 {
-		return db.Conn().Updates(model).Error
+		if err := db.Conn().Updates(model).Error; err != nil {
+			return err
+		}
+		db.AfterUpdateHook(model)
+		return nil
 	}
 
 	data, err := Changeset(model, names)
@@ -2,22 +2,33 @@
 package gormutil
 
 import (
+	"fmt"
 	"sync"
 	"time"
 
 	"github.com/go-playground/validator/v10"
 	"gorm.io/gorm"
 	"gorm.io/gorm/logger"
+	"gorm.io/gorm/schema"
+
+	"github.com/avakarev/go-util/httputil"
 )
 
 // DB defines db container
 type DB struct {
 	mu           sync.Mutex
 	locksEnabled bool
+	connMu       sync.RWMutex
 	conn         *gorm.DB
+	dialector    gorm.Dialector
 	config       *gorm.Config
 	validate     *validator.Validate
 	hooks        *HookBus
+	cache        *modelCache
+	tx           bool
+	pending      []*Hook // hooks published within transaction, they're published on commit
+	search       *searchRegistry
+	searchLang   string
 }
 
 // ConfigureFunc defines configurator func
@@ -25,28 +36,54 @@ type ConfigureFunc func(*DB) error
 
 // Conn returns gorm's connection
 func (db *DB) Conn() *gorm.DB {
+	db.connMu.RLock()
+	defer db.connMu.RUnlock()
 	return db.conn
 }
 
+// Schema parses and returns gorm schema of given model
+func (db *DB) Schema(model any) (*schema.Schema, error) {
+	stmt := &gorm.Statement{DB: db.Conn()}
+	if err := stmt.Parse(model); err != nil {
+		return nil, err
+	}
+	return stmt.Schema, nil
+}
+
 // Begin begins a transaction
 func (db *DB) Begin() *DB {
 	return &DB{
 		locksEnabled: db.locksEnabled,
-		conn:         db.conn.Begin(),
+		conn:         db.Conn().Begin(),
+		dialector:    db.dialector,
 		config:       db.config,
 		validate:     db.validate,
 		hooks:        db.hooks,
+		cache:        db.cache,
+		tx:           true,
+		search:       db.search,
+		searchLang:   db.searchLang,
 	}
 }
 
-// Rollback rollbacks the transaction
+// Rollback rollbacks the transaction, hooks published within it are dropped
 func (db *DB) Rollback() {
+	db.pending = nil
 	db.conn.Rollback()
 }
 
-// Commit commits the transaction
+// Commit commits the transaction and publishes hooks deferred within it,
+// so that neither subscribers nor cache invalidation see uncommitted changes
 func (db *DB) Commit() error {
-	return db.conn.Commit().Error
+	pending := db.pending
+	db.pending = nil
+	if err := db.conn.Commit().Error; err != nil {
+		return err
+	}
+	for _, hook := range pending {
+		db.hooks.publish(hook)
+	}
+	return nil
 }
 
 // RegisterValidation adds a custom validation for the given tag
@@ -54,7 +91,8 @@ func (db *DB) RegisterValidation(tag string, fn validator.Func) error {
 	return db.validate.RegisterValidation(tag, fn)
 }
 
-// RegisterValidationTagNameFunc registers a function to get alternate names for StructFields
+// RegisterValidationTagNameFunc registers a function to get alternate names for StructFields,
+// by default names follow json tags, see httputil.NewValidator
 func (db *DB) RegisterValidationTagNameFunc(fn validator.TagNameFunc) {
 	db.validate.RegisterTagNameFunc(fn)
 }
@@ -66,25 +104,32 @@ func (db *DB) SubscribeHook(model any, fn HookHandlerFunc) {
 	}
 }
 
+// publishHook publishes hook of given model, within transaction it's deferred until commit
+func (db *DB) publishHook(model any, event HookEvent) {
+	if db.hooks == nil {
+		return
+	}
+	hook := newHook(model, event)
+	if db.tx {
+		db.pending = append(db.pending, hook)
+		return
+	}
+	db.hooks.publish(hook)
+}
+
 // AfterCreateHook publishes hook after create
 func (db *DB) AfterCreateHook(model any) {
-	if db.hooks != nil {
-		db.hooks.publish(model, HookEvent(HookAfterCreate))
-	}
+	db.publishHook(model, HookEvent(HookAfterCreate))
 }
 
 // AfterUpdateHook publishes hook after update
 func (db *DB) AfterUpdateHook(model any) {
-	if db.hooks != nil {
-		db.hooks.publish(model, HookEvent(HookAfterUpdate))
-	}
+	db.publishHook(model, HookEvent(HookAfterUpdate))
 }
 
 // AfterDeleteHook publishes hook after delete
 func (db *DB) AfterDeleteHook(model any) {
-	if db.hooks != nil {
-		db.hooks.publish(model, HookEvent(HookAfterDelete))
-	}
+	db.publishHook(model, HookEvent(HookAfterDelete))
 }
 
 // WithHooks enables hooks pub/sub
@@ -103,6 +148,17 @@ func WithLocks() ConfigureFunc {
 	}
 }
 
+// WithCache enables read-through cache of models, invalidated by create/update/delete hooks.
+// Writes made directly via Conn don't publish hooks, so they bypass invalidation.
+func WithCache(c Cache) ConfigureFunc {
+	return func(db *DB) error {
+		db.WithHooks()
+		db.cache = newModelCache(c)
+		db.hooks.listen(db.cache.invalidate)
+		return nil
+	}
+}
+
 // WithLogger sets given logger as gorm logger
 func WithLogger(l logger.Interface) ConfigureFunc {
 	return func(db *DB) error {
@@ -119,9 +175,25 @@ func WithNowFunc(fn func() time.Time) ConfigureFunc {
 	}
 }
 
+// WithSearchLanguage sets postgres text search configuration used by full-text search, e.g. "simple"
+func WithSearchLanguage(lang string) ConfigureFunc {
+	return func(db *DB) error {
+		if !searchLangRe.MatchString(lang) {
+			return fmt.Errorf("invalid search language %q", lang)
+		}
+		db.searchLang = lang
+		return nil
+	}
+}
+
 // Open initializes db session based on dialector
 func Open(dialector gorm.Dialector, fns ...ConfigureFunc) (*DB, error) {
-	db := &DB{config: &gorm.Config{}, validate: validator.New()}
+	db := &DB{
+		config:     &gorm.Config{},
+		validate:   httputil.NewValidator(),
+		search:     newSearchRegistry(),
+		searchLang: defaultSearchLang,
+	}
 	for _, fn := range fns {
 		if err := fn(db); err != nil {
 			return nil, err
@@ -132,5 +204,6 @@ func Open(dialector gorm.Dialector, fns ...ConfigureFunc) (*DB, error) {
 		return nil, err
 	}
 	db.conn = conn
+	db.dialector = dialector
 	return db, nil
 }
@@ -0,0 +1,24 @@
+package gormutil_test
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/glebarez/sqlite"
+
+	"github.com/avakarev/go-util/testutil"
+
+	"github.com/avakarev/go-util/gormutil"
+)
+
+// openDB opens sqlite database backed by file in test's temp dir
+func openDB(t *testing.T, fns ...gormutil.ConfigureFunc) *gormutil.DB {
+	db, err := gormutil.Open(sqlite.Open(filepath.Join(t.TempDir(), "test.db")), fns...)
+	testutil.MustNoErr(err, t)
+	t.Cleanup(func() {
+		if conn, err := db.Conn().DB(); err == nil {
+			_ = conn.Close()
+		}
+	})
+	return db
+}
@@ -64,19 +64,34 @@ type HookBus struct {
 
 	// publishChan broadcasts given hook to its subscriptions
 	publishChan chan *Hook
+
+	// listeners are called synchronously on publish, regardless of table
+	listeners []HookHandlerFunc
 }
 
-func (hb *HookBus) publish(model any, event HookEvent) {
-	hb.publishChan <- &Hook{
-		Table: tableName(model),
+func newHook(model any, event HookEvent) *Hook {
+	return &Hook{
+		Table: TableName(model),
 		Model: model,
 		Event: event,
 	}
 }
 
+func (hb *HookBus) publish(hook *Hook) {
+	for _, fn := range hb.listeners {
+		fn(hook)
+	}
+	hb.publishChan <- hook
+}
+
+// listen adds given listener, it isn't safe to be called after bus started publishing
+func (hb *HookBus) listen(fn HookHandlerFunc) {
+	hb.listeners = append(hb.listeners, fn)
+}
+
 func (hb *HookBus) subscribe(model any, fn HookHandlerFunc) {
 	hb.subscribeChan <- &HookSubscription{
-		table:   tableName(model),
+		table:   TableName(model),
 		handler: fn,
 	}
 }
@@ -104,7 +119,8 @@ func (hb *HookBus) run() {
 	}
 }
 
-func tableName(v any) string {
+// TableName returns table name of given model as it is used in hooks
+func TableName(v any) string {
 	value := reflect.ValueOf(v)
 	modelType := reflect.Indirect(value).Type()
 	if modelType.Kind() == reflect.Pointer {
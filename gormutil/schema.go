@@ -0,0 +1,336 @@
+package gormutil
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// ColumnInfo defines table column
+type ColumnInfo struct {
+	Name       string `json:"name"`
+	Type       string `json:"type"`
+	Nullable   bool   `json:"nullable"`
+	PrimaryKey bool   `json:"primaryKey"`
+	Unique     bool   `json:"unique"`
+	Default    string `json:"default,omitempty"`
+}
+
+// IndexInfo defines table index
+type IndexInfo struct {
+	Name       string   `json:"name"`
+	Columns    []string `json:"columns"`
+	Unique     bool     `json:"unique"`
+	PrimaryKey bool     `json:"primaryKey"`
+}
+
+// ForeignKeyInfo defines table foreign key
+type ForeignKeyInfo struct {
+	Name       string   `json:"name,omitempty"`
+	Columns    []string `json:"columns"`
+	RefTable   string   `json:"refTable"`
+	RefColumns []string `json:"refColumns"`
+}
+
+// TableInfo defines table schema
+type TableInfo struct {
+	Name        string           `json:"name"`
+	Columns     []ColumnInfo     `json:"columns"`
+	Indexes     []IndexInfo      `json:"indexes"`
+	ForeignKeys []ForeignKeyInfo `json:"foreignKeys"`
+}
+
+// Column returns column by given name
+func (t *TableInfo) Column(name string) *ColumnInfo {
+	for i := range t.Columns {
+		if strings.EqualFold(t.Columns[i].Name, name) {
+			return &t.Columns[i]
+		}
+	}
+	return nil
+}
+
+// InspectTable returns schema of the given table as it exists in the db
+func (db *DB) InspectTable(table string) (*TableInfo, error) {
+	info := &TableInfo{
+		Name:        table,
+		Columns:     make([]ColumnInfo, 0),
+		Indexes:     make([]IndexInfo, 0),
+		ForeignKeys: make([]ForeignKeyInfo, 0),
+	}
+	m := db.Conn().Migrator()
+
+	columns, err := m.ColumnTypes(table)
+	if err != nil {
+		return nil, err
+	}
+	for _, c := range columns {
+		col := ColumnInfo{Name: c.Name(), Type: c.DatabaseTypeName()}
+		if t, ok := c.ColumnType(); ok && t != "" {
+			col.Type = t
+		}
+		col.Nullable, _ = c.Nullable()
+		col.PrimaryKey, _ = c.PrimaryKey()
+		col.Unique, _ = c.Unique()
+		col.Default, _ = c.DefaultValue()
+		info.Columns = append(info.Columns, col)
+	}
+
+	indexes, err := m.GetIndexes(table)
+	if err != nil {
+		return nil, err
+	}
+	for _, idx := range indexes {
+		i := IndexInfo{Name: idx.Name(), Columns: idx.Columns()}
+		i.Unique, _ = idx.Unique()
+		i.PrimaryKey, _ = idx.PrimaryKey()
+		info.Indexes = append(info.Indexes, i)
+	}
+
+	fks, err := db.foreignKeys(table)
+	if err != nil {
+		return nil, err
+	}
+	info.ForeignKeys = fks
+
+	return info, nil
+}
+
+// Inspect returns schema of existing db tables respecting the given filter
+func (db *DB) Inspect(filter *TableFilter) ([]TableInfo, error) {
+	tables, err := db.Tables(filter)
+	if err != nil {
+		return nil, err
+	}
+	infos := make([]TableInfo, 0, len(tables))
+	for _, t := range tables {
+		info, err := db.InspectTable(t)
+		if err != nil {
+			return nil, err
+		}
+		infos = append(infos, *info)
+	}
+	return infos, nil
+}
+
+type foreignKeyRow struct {
+	Name      string
+	Column    string
+	RefTable  string
+	RefColumn string
+}
+
+func (db *DB) foreignKeys(table string) ([]ForeignKeyInfo, error) {
+	var rows []foreignKeyRow
+	var err error
+	switch db.Conn().Dialector.Name() {
+	case "sqlite":
+		var list []struct {
+			ID    int
+			Table string
+			From  string
+			To    string
+		}
+		err = db.Conn().Raw(fmt.Sprintf("PRAGMA foreign_key_list(%s)", db.quote(table))).Scan(&list).Error
+		for _, fk := range list {
+			rows = append(rows, foreignKeyRow{Name: fmt.Sprint(fk.ID), Column: fk.From, RefTable: fk.Table, RefColumn: fk.To})
+		}
+	case "postgres":
+		err = db.Conn().Raw(`SELECT tc.constraint_name AS name, kcu.column_name AS "column",
+			ccu.table_name AS ref_table, ccu.column_name AS ref_column
+			FROM information_schema.table_constraints tc
+			JOIN information_schema.key_column_usage kcu
+				ON tc.constraint_name = kcu.constraint_name AND tc.table_schema = kcu.table_schema
+			JOIN information_schema.constraint_column_usage ccu
+				ON ccu.constraint_name = tc.constraint_name AND ccu.table_schema = tc.table_schema
+			WHERE tc.constraint_type = 'FOREIGN KEY' AND tc.table_name = ? AND tc.table_schema = CURRENT_SCHEMA()
+			ORDER BY tc.constraint_name, kcu.ordinal_position`, table).Scan(&rows).Error
+	case "mysql":
+		err = db.Conn().Raw(`SELECT constraint_name AS name, column_name AS `+"`column`"+`,
+			referenced_table_name AS ref_table, referenced_column_name AS ref_column
+			FROM information_schema.key_column_usage
+			WHERE referenced_table_name IS NOT NULL AND table_name = ? AND table_schema = DATABASE()
+			ORDER BY constraint_name, ordinal_position`, table).Scan(&rows).Error
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	fks := make([]ForeignKeyInfo, 0)
+	for _, r := range rows {
+		if n := len(fks); n > 0 && fks[n-1].Name == r.Name && fks[n-1].RefTable == r.RefTable {
+			fks[n-1].Columns = append(fks[n-1].Columns, r.Column)
+			fks[n-1].RefColumns = append(fks[n-1].RefColumns, r.RefColumn)
+			continue
+		}
+		fks = append(fks, ForeignKeyInfo{
+			Name:       r.Name,
+			Columns:    []string{r.Column},
+			RefTable:   r.RefTable,
+			RefColumns: []string{r.RefColumn},
+		})
+	}
+	return fks, nil
+}
+
+// DriftKind defines kind of schema drift
+type DriftKind string
+
+const (
+	// DriftMissingTable is reported when model's table doesn't exist
+	DriftMissingTable DriftKind = "missing table"
+	// DriftMissingColumn is reported when model's column doesn't exist
+	DriftMissingColumn DriftKind = "missing column"
+	// DriftExtraColumn is reported when table has column not declared by model
+	DriftExtraColumn DriftKind = "extra column"
+	// DriftTypeMismatch is reported when column type differs from model's one
+	DriftTypeMismatch DriftKind = "type mismatch"
+	// DriftMissingIndex is reported when model's index doesn't exist
+	DriftMissingIndex DriftKind = "missing index"
+)
+
+// Drift defines single difference between live schema and model
+type Drift struct {
+	Kind   DriftKind `json:"kind"`
+	Table  string    `json:"table"`
+	Column string    `json:"column,omitempty"`
+	Index  string    `json:"index,omitempty"`
+	Want   string    `json:"want,omitempty"`
+	Got    string    `json:"got,omitempty"`
+}
+
+// String returns drift's string representation
+func (d Drift) String() string {
+	s := fmt.Sprintf("%s %q", d.Kind, d.Table)
+	if d.Column != "" {
+		s += fmt.Sprintf(", column=%q", d.Column)
+	}
+	if d.Index != "" {
+		s += fmt.Sprintf(", index=%q", d.Index)
+	}
+	if d.Want != "" || d.Got != "" {
+		s += fmt.Sprintf(", want=%q, got=%q", d.Want, d.Got)
+	}
+	return s
+}
+
+// DriftReport defines list of differences between live schema and models
+type DriftReport struct {
+	Items []Drift `json:"items"`
+}
+
+// Err returns error listing all drifts, or nil if there are none
+func (r *DriftReport) Err() error {
+	if len(r.Items) == 0 {
+		return nil
+	}
+	errs := make([]error, len(r.Items))
+	for i, d := range r.Items {
+		errs[i] = errors.New(d.String())
+	}
+	return fmt.Errorf("schema drift detected:\n%w", errors.Join(errs...))
+}
+
+// baseType returns lower-cased column type without size/precision suffix, e.g. "varchar" for "VARCHAR(255)"
+func baseType(typ string) string {
+	typ = strings.ToLower(typ)
+	if i := strings.Index(typ, "("); i >= 0 {
+		if j := strings.Index(typ[i:], ")"); j >= 0 {
+			typ = typ[:i] + typ[i+j+1:]
+		}
+	}
+	return strings.Join(strings.Fields(typ), " ")
+}
+
+func (db *DB) sameType(declared string, realType string) bool {
+	declared, realType = baseType(declared), baseType(realType)
+	if declared == "" || realType == "" {
+		return false
+	}
+	if declared == realType {
+		return true
+	}
+	for _, alias := range db.Conn().Migrator().GetTypeAliases(realType) {
+		if baseType(alias) == declared {
+			return true
+		}
+	}
+	return false
+}
+
+// Drift compares live schema with given models and reports differences
+func (db *DB) Drift(models ...any) (*DriftReport, error) {
+	report := &DriftReport{Items: make([]Drift, 0)}
+	m := db.Conn().Migrator()
+	for _, model := range models {
+		sch, err := db.Schema(model)
+		if err != nil {
+			return nil, err
+		}
+		if !m.HasTable(sch.Table) {
+			report.Items = append(report.Items, Drift{Kind: DriftMissingTable, Table: sch.Table})
+			continue
+		}
+
+		columns, err := m.ColumnTypes(sch.Table)
+		if err != nil {
+			return nil, err
+		}
+		declared := make([]string, 0, len(sch.DBNames))
+		for _, name := range sch.DBNames {
+			f := sch.LookUpField(name)
+			if f == nil || f.IgnoreMigration {
+				continue
+			}
+			declared = append(declared, strings.ToLower(name))
+			idx := slices.IndexFunc(columns, func(c gorm.ColumnType) bool {
+				return strings.EqualFold(c.Name(), name)
+			})
+			if idx < 0 {
+				report.Items = append(report.Items, Drift{Kind: DriftMissingColumn, Table: sch.Table, Column: name})
+				continue
+			}
+			// primary keys aren't type checked, same as gorm migrator does
+			if f.PrimaryKey {
+				continue
+			}
+			declaredType := db.Conn().Dialector.DataTypeOf(f)
+			if realType := columns[idx].DatabaseTypeName(); !db.sameType(declaredType, realType) {
+				report.Items = append(report.Items, Drift{
+					Kind:   DriftTypeMismatch,
+					Table:  sch.Table,
+					Column: name,
+					Want:   strings.TrimSpace(strings.ToLower(declaredType)),
+					Got:    strings.ToLower(realType),
+				})
+			}
+		}
+		managed := db.managedColumns(sch.Table)
+		for _, c := range columns {
+			name := strings.ToLower(c.Name())
+			if slices.Contains(declared, name) || slices.Contains(managed, name) {
+				continue
+			}
+			report.Items = append(report.Items, Drift{Kind: DriftExtraColumn, Table: sch.Table, Column: c.Name()})
+		}
+
+		for _, idx := range sch.ParseIndexes() {
+			if !m.HasIndex(model, idx.Name) {
+				report.Items = append(report.Items, Drift{Kind: DriftMissingIndex, Table: sch.Table, Index: idx.Name})
+			}
+		}
+	}
+	return report, nil
+}
+
+// CheckDrift returns error if live schema differs from given models
+func (db *DB) CheckDrift(models ...any) error {
+	report, err := db.Drift(models...)
+	if err != nil {
+		return err
+	}
+	return report.Err()
+}
@@ -0,0 +1,173 @@
+package gormutil_test
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/glebarez/sqlite"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+
+	"github.com/avakarev/go-util/testutil"
+
+	"github.com/avakarev/go-util/gormutil"
+)
+
+func TestDriftReportErr(t *testing.T) {
+	report := &gormutil.DriftReport{}
+	testutil.MustNoErr(report.Err(), t)
+
+	report.Items = []gormutil.Drift{
+		{Kind: gormutil.DriftMissingTable, Table: "users"},
+		{Kind: gormutil.DriftTypeMismatch, Table: "posts", Column: "title", Want: "text", Got: "integer"},
+		{Kind: gormutil.DriftMissingIndex, Table: "posts", Index: "idx_posts_slug"},
+	}
+	testutil.MustErr(errors.New(`schema drift detected:
+missing table "users"
+type mismatch "posts", column="title", want="text", got="integer"
+missing index "posts", index="idx_posts_slug"`), report.Err(), t)
+}
+
+type author struct {
+	gormutil.ModelBase
+	Name  string `gorm:"index"`
+	Posts []post
+}
+
+type post struct {
+	gormutil.ModelBase
+	AuthorID uuid.UUID `gorm:"type:uuid"`
+	Title    string
+	Score    int
+}
+
+// postV2 is next version of post model, not migrated yet
+type postV2 struct {
+	gormutil.ModelBase
+	AuthorID uuid.UUID `gorm:"type:uuid"`
+	Title    int
+	Slug     string `gorm:"uniqueIndex"`
+}
+
+func (postV2) TableName() string {
+	return "posts"
+}
+
+type comment struct {
+	gormutil.ModelBase
+}
+
+func TestInspectTable(t *testing.T) {
+	db := openDB(t)
+	testutil.MustNoErr(db.Conn().AutoMigrate(&author{}, &post{}), t)
+
+	info, err := db.InspectTable("posts")
+	testutil.MustNoErr(err, t)
+	testutil.Diff(&gormutil.TableInfo{
+		Name: "posts",
+		Columns: []gormutil.ColumnInfo{
+			{Name: "id", Type: "uuid", Nullable: true, PrimaryKey: true},
+			{Name: "created_at", Type: "datetime", Nullable: true},
+			{Name: "updated_at", Type: "datetime", Nullable: true},
+			{Name: "author_id", Type: "uuid", Nullable: true},
+			{Name: "title", Type: "text", Nullable: true},
+			{Name: "score", Type: "integer", Nullable: true},
+		},
+		Indexes: []gormutil.IndexInfo{
+			{Name: "idx_posts_id", Columns: []string{"id"}, Unique: true},
+			{Name: "sqlite_autoindex_posts_1", Columns: []string{"id"}, Unique: true, PrimaryKey: true},
+		},
+		ForeignKeys: []gormutil.ForeignKeyInfo{
+			{Name: "0", Columns: []string{"author_id"}, RefTable: "authors", RefColumns: []string{"id"}},
+		},
+	}, info, t)
+	testutil.Diff("title", info.Column("TITLE").Name, t)
+	testutil.Diff(true, info.Column("slug") == nil, t)
+
+	infos, err := db.Inspect(&gormutil.TableFilter{IncludeTables: []string{"authors"}})
+	testutil.MustNoErr(err, t)
+	testutil.Diff(1, len(infos), t)
+	testutil.Diff([]string{"idx_authors_id", "idx_authors_name", "sqlite_autoindex_authors_1"}, indexNames(infos[0]), t)
+	testutil.Diff([]gormutil.ForeignKeyInfo{}, infos[0].ForeignKeys, t)
+}
+
+func indexNames(info gormutil.TableInfo) []string {
+	names := make([]string, 0, len(info.Indexes))
+	for _, idx := range info.Indexes {
+		names = append(names, idx.Name)
+	}
+	return names
+}
+
+func TestDrift(t *testing.T) {
+	db := openDB(t)
+	testutil.MustNoErr(db.Conn().AutoMigrate(&author{}, &post{}), t)
+	testutil.MustNoErr(db.CheckDrift(&author{}, &post{}), t)
+
+	report, err := db.Drift(&author{}, &postV2{}, &comment{})
+	testutil.MustNoErr(err, t)
+	testutil.Diff([]gormutil.Drift{
+		{Kind: gormutil.DriftTypeMismatch, Table: "posts", Column: "title", Want: "integer", Got: "text"},
+		{Kind: gormutil.DriftMissingColumn, Table: "posts", Column: "slug"},
+		{Kind: gormutil.DriftExtraColumn, Table: "posts", Column: "score"},
+		{Kind: gormutil.DriftMissingIndex, Table: "posts", Index: "idx_posts_slug"},
+		{Kind: gormutil.DriftMissingTable, Table: "comments"},
+	}, report.Items, t)
+
+	// index dropped behind gorm's back
+	testutil.MustNoErr(db.Conn().Exec("DROP INDEX idx_authors_name").Error, t)
+	testutil.MustErr(errors.New(`schema drift detected:
+missing index "authors", index="idx_authors_name"`), db.CheckDrift(&author{}, &post{}), t)
+}
+
+// aliasDialector reports type aliases the way postgres dialect does
+type aliasDialector struct {
+	gorm.Dialector
+	aliases map[string][]string
+}
+
+func (d aliasDialector) Migrator(db *gorm.DB) gorm.Migrator {
+	return aliasMigrator{Migrator: d.Dialector.Migrator(db), aliases: d.aliases}
+}
+
+type aliasMigrator struct {
+	gorm.Migrator
+	aliases map[string][]string
+}
+
+func (m aliasMigrator) GetTypeAliases(databaseTypeName string) []string {
+	return m.aliases[databaseTypeName]
+}
+
+type gauge struct {
+	ID    uuid.UUID `gorm:"type:uuid;primaryKey"`
+	Value int
+}
+
+func TestDriftTypeAliases(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+	db, err := gormutil.Open(aliasDialector{Dialector: sqlite.Open(path), aliases: map[string][]string{"mediumint": {"integer"}}})
+	testutil.MustNoErr(err, t)
+	testutil.MustNoErr(db.Conn().Exec("CREATE TABLE gauges (id uuid PRIMARY KEY, value mediumint)").Error, t)
+	testutil.MustNoErr(db.CheckDrift(&gauge{}), t)
+
+	db, err = gormutil.Open(sqlite.Open(path))
+	testutil.MustNoErr(err, t)
+	testutil.MustErr(errors.New(`schema drift detected:
+type mismatch "gauges", column="value", want="integer", got="mediumint"`), db.CheckDrift(&gauge{}), t)
+}
+
+type interval struct {
+	ID       uuid.UUID `gorm:"type:uuid;primaryKey"`
+	Code     string    `gorm:"type:varchar(64)"`
+	Duration string    `gorm:"type:interval"`
+}
+
+func TestDriftBaseType(t *testing.T) {
+	db := openDB(t)
+	testutil.MustNoErr(db.Conn().Exec("CREATE TABLE intervals (id uuid PRIMARY KEY, code VARCHAR(32), duration int)").Error, t)
+	// size suffix is ignored, base type is compared exactly
+	testutil.MustErr(errors.New(`schema drift detected:
+type mismatch "intervals", column="duration", want="interval", got="int"`), db.CheckDrift(&interval{}), t)
+}
@@ -0,0 +1,373 @@
+package gormutil
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strings"
+	"sync"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	defaultSearchLang  = "english"
+	defaultSearchLimit = 20
+
+	// SearchVectorColumn is postgres tsvector column name added to searchable tables
+	SearchVectorColumn = "search_vector"
+
+	// searchRankAlias is alias of selected hit rank, hits are ordered by it
+	searchRankAlias = "search_rank"
+)
+
+var searchLangRe = regexp.MustCompile(`^[a-z_]+$`)
+
+// ErrSearchUnsupported is returned when full-text search isn't supported by db dialect
+var ErrSearchUnsupported = errors.New("full-text search is not supported by dialect")
+
+// SearchOptions defines search query options
+type SearchOptions struct {
+	// Limit defines page size, defaults to 20
+	Limit int
+	// Offset defines number of hits to skip
+	Offset int
+	// Highlight enables highlighting of matched terms
+	Highlight bool
+	// HighlightStart defines opening highlight marker, defaults to "<mark>"
+	HighlightStart string
+	// HighlightEnd defines closing highlight marker, defaults to "</mark>"
+	HighlightEnd string
+	// Raw passes query to the engine as is (FTS5 query syntax in sqlite),
+	// otherwise each query term is matched literally
+	Raw bool
+}
+
+func (opts *SearchOptions) withDefaults() *SearchOptions {
+	cp := SearchOptions{}
+	if opts != nil {
+		cp = *opts
+	}
+	if cp.Limit <= 0 {
+		cp.Limit = defaultSearchLimit
+	}
+	if cp.Offset < 0 {
+		cp.Offset = 0
+	}
+	if cp.HighlightStart == "" {
+		cp.HighlightStart = "<mark>"
+	}
+	if cp.HighlightEnd == "" {
+		cp.HighlightEnd = "</mark>"
+	}
+	return &cp
+}
+
+// SearchResult defines single search hit
+type SearchResult[T any] struct {
+	Model *T
+	// Rank defines hit relevance, the higher the better
+	Rank float64
+	// Highlights maps searchable column names to their highlighted text
+	Highlights map[string]string
+}
+
+// SearchPage defines page of search hits
+type SearchPage[T any] struct {
+	Results []SearchResult[T]
+	Total   int64
+	Limit   int
+	Offset  int
+}
+
+type searchIndex struct {
+	table   string
+	pk      string
+	columns []string
+	// managed lists columns the index adds to the table, they aren't reported as drift
+	managed []string
+}
+
+// searchRegistry holds search indexes by table, it's shared by db and its transactions
+type searchRegistry struct {
+	mu      sync.RWMutex
+	indexes map[string]*searchIndex
+}
+
+func newSearchRegistry() *searchRegistry {
+	return &searchRegistry{indexes: make(map[string]*searchIndex)}
+}
+
+func (r *searchRegistry) get(table string) (*searchIndex, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	idx, ok := r.indexes[table]
+	return idx, ok
+}
+
+func (r *searchRegistry) set(idx *searchIndex) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.indexes[idx.table] = idx
+}
+
+// managedColumns returns columns added to the given table by its search index, see Drift
+func (db *DB) managedColumns(table string) []string {
+	if idx, ok := db.search.get(table); ok {
+		return idx.managed
+	}
+	return nil
+}
+
+func (idx *searchIndex) ftsTable() string {
+	return idx.table + "_fts"
+}
+
+// RegisterSearchable creates full-text index over given model fields.
+//
+// In sqlite it creates FTS5 external content table "<table>_fts" kept in sync by triggers,
+// in postgres it adds generated tsvector column with GIN index.
+// Therefore index stays in sync with Create/Update/Delete and any other write to the table.
+func (db *DB) RegisterSearchable(model any, fields ...string) error {
+	if len(fields) == 0 {
+		return errors.New("searchable fields are required")
+	}
+	sch, err := db.Schema(model)
+	if err != nil {
+		return err
+	}
+	if sch.PrioritizedPrimaryField == nil {
+		return fmt.Errorf("model <%T> has no primary key", model)
+	}
+	idx := &searchIndex{
+		table:   sch.Table,
+		pk:      sch.PrioritizedPrimaryField.DBName,
+		columns: make([]string, len(fields)),
+	}
+	for i, name := range fields {
+		f := sch.LookUpField(name)
+		if f == nil || f.DBName == "" {
+			return fmt.Errorf("model <%T> doesn't have %s field", model, name)
+		}
+		idx.columns[i] = f.DBName
+	}
+
+	switch db.Conn().Dialector.Name() {
+	case "sqlite":
+		err = db.createFTS5Index(idx)
+	case "postgres":
+		err = db.createTSVectorIndex(idx)
+	default:
+		err = fmt.Errorf("%w %q", ErrSearchUnsupported, db.Conn().Dialector.Name())
+	}
+	if err != nil {
+		return err
+	}
+
+	db.search.set(idx)
+	return nil
+}
+
+func (db *DB) quote(name string) string {
+	return db.Conn().Statement.Quote(name)
+}
+
+func (db *DB) quoteAll(names []string, prefix string) string {
+	quoted := make([]string, len(names))
+	for i, n := range names {
+		quoted[i] = prefix + db.quote(n)
+	}
+	return strings.Join(quoted, ", ")
+}
+
+func (db *DB) createFTS5Index(idx *searchIndex) error {
+	fts := idx.ftsTable()
+	exists := db.Conn().Migrator().HasTable(fts)
+	cols := db.quoteAll(idx.columns, "")
+	newCols := db.quoteAll(idx.columns, "new.")
+	oldCols := db.quoteAll(idx.columns, "old.")
+	insertNew := fmt.Sprintf("INSERT INTO %s(rowid, %s) VALUES (new.rowid, %s);", db.quote(fts), cols, newCols)
+	deleteOld := fmt.Sprintf("INSERT INTO %s(%s, rowid, %s) VALUES ('delete', old.rowid, %s);",
+		db.quote(fts), db.quote(fts), cols, oldCols)
+
+	stmts := []string{
+		fmt.Sprintf("CREATE VIRTUAL TABLE IF NOT EXISTS %s USING fts5(%s, content='%s', content_rowid='rowid')",
+			db.quote(fts), cols, idx.table),
+		fmt.Sprintf("CREATE TRIGGER IF NOT EXISTS %s AFTER INSERT ON %s BEGIN %s END",
+			db.quote(fts+"_ai"), db.quote(idx.table), insertNew),
+		fmt.Sprintf("CREATE TRIGGER IF NOT EXISTS %s AFTER DELETE ON %s BEGIN %s END",
+			db.quote(fts+"_ad"), db.quote(idx.table), deleteOld),
+		fmt.Sprintf("CREATE TRIGGER IF NOT EXISTS %s AFTER UPDATE ON %s BEGIN %s %s END",
+			db.quote(fts+"_au"), db.quote(idx.table), deleteOld, insertNew),
+	}
+	if !exists {
+		// index rows inserted before the index was created
+		stmts = append(stmts, fmt.Sprintf("INSERT INTO %s(%s) VALUES ('rebuild')", db.quote(fts), db.quote(fts)))
+	}
+	return db.Conn().Transaction(func(tx *gorm.DB) error {
+		for _, stmt := range stmts {
+			if err := tx.Exec(stmt).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (db *DB) createTSVectorIndex(idx *searchIndex) error {
+	parts := make([]string, len(idx.columns))
+	for i, c := range idx.columns {
+		parts[i] = fmt.Sprintf("coalesce(%s::text, '')", db.quote(c))
+	}
+	stmts := []string{
+		fmt.Sprintf("ALTER TABLE %s ADD COLUMN IF NOT EXISTS %s tsvector GENERATED ALWAYS AS (to_tsvector('%s', %s)) STORED",
+			db.quote(idx.table), db.quote(SearchVectorColumn), db.searchLang, strings.Join(parts, " || ' ' || ")),
+		fmt.Sprintf("CREATE INDEX IF NOT EXISTS %s ON %s USING GIN (%s)",
+			db.quote("idx_"+idx.table+"_"+SearchVectorColumn), db.quote(idx.table), db.quote(SearchVectorColumn)),
+	}
+	idx.managed = []string{SearchVectorColumn}
+	return db.Conn().Transaction(func(tx *gorm.DB) error {
+		for _, stmt := range stmts {
+			if err := tx.Exec(stmt).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// fts5Query quotes each query term so it's matched literally
+func fts5Query(query string) string {
+	terms := strings.Fields(query)
+	for i, t := range terms {
+		terms[i] = `"` + strings.ReplaceAll(t, `"`, `""`) + `"`
+	}
+	return strings.Join(terms, " ")
+}
+
+// searchScope returns scope filtering model's table by full-text match along with hit columns to select.
+// Scoped query goes through gorm's query callbacks, so that default scopes, e.g. soft delete,
+// apply to both hits and their total.
+func (db *DB) searchScope(idx *searchIndex, query string, opts *SearchOptions) (func(*gorm.DB) *gorm.DB, string, []any) {
+	table := db.quote(idx.table)
+	args := make([]any, 0)
+	cols := []string{}
+	switch db.Conn().Dialector.Name() {
+	case "sqlite":
+		if !opts.Raw {
+			query = fts5Query(query)
+		}
+		fts := db.quote(idx.ftsTable())
+		cols = append(cols, table+"."+db.quote(idx.pk), fmt.Sprintf("-bm25(%s) AS %s", fts, searchRankAlias))
+		if opts.Highlight {
+			for i := range idx.columns {
+				cols = append(cols, fmt.Sprintf("highlight(%s, %d, ?, ?)", fts, i))
+				args = append(args, opts.HighlightStart, opts.HighlightEnd)
+			}
+		}
+		scope := func(tx *gorm.DB) *gorm.DB {
+			return tx.Joins(fmt.Sprintf("JOIN %s ON %s.rowid = %s.rowid", fts, fts, table)).
+				Where(fmt.Sprintf("%s MATCH ?", fts), query)
+		}
+		return scope, strings.Join(cols, ", "), args
+	default: // postgres
+		tsq := fmt.Sprintf("websearch_to_tsquery('%s', ?)", db.searchLang)
+		if opts.Raw {
+			tsq = fmt.Sprintf("to_tsquery('%s', ?)", db.searchLang)
+		}
+		vector := table + "." + db.quote(SearchVectorColumn)
+		cols = append(cols, fmt.Sprintf("%s.%s::text", table, db.quote(idx.pk)), fmt.Sprintf("ts_rank(%s, q) AS %s", vector, searchRankAlias))
+		if opts.Highlight {
+			hlOpts := fmt.Sprintf("StartSel=%q, StopSel=%q, HighlightAll=true", opts.HighlightStart, opts.HighlightEnd)
+			for _, c := range idx.columns {
+				cols = append(cols, fmt.Sprintf("ts_headline('%s', coalesce(%s.%s::text, ''), q, ?)", db.searchLang, table, db.quote(c)))
+				args = append(args, hlOpts)
+			}
+		}
+		scope := func(tx *gorm.DB) *gorm.DB {
+			return tx.Joins(fmt.Sprintf("CROSS JOIN %s q", tsq), query).Where(fmt.Sprintf("%s @@ q", vector))
+		}
+		return scope, strings.Join(cols, ", "), args
+	}
+}
+
+// Search runs full-text query against searchable model T and returns page of ranked hits
+func Search[T any](db *DB, query string, opts *SearchOptions) (*SearchPage[T], error) {
+	sch, err := db.Schema(new(T))
+	if err != nil {
+		return nil, err
+	}
+	idx, ok := db.search.get(sch.Table)
+	if !ok {
+		return nil, fmt.Errorf("table %q is not registered as searchable", sch.Table)
+	}
+	opts = opts.withDefaults()
+	page := &SearchPage[T]{Results: make([]SearchResult[T], 0), Limit: opts.Limit, Offset: opts.Offset}
+	if strings.TrimSpace(query) == "" {
+		return page, nil
+	}
+
+	scope, cols, colArgs := db.searchScope(idx, query, opts)
+	if err := db.Conn().Model(new(T)).Scopes(scope).Count(&page.Total).Error; err != nil {
+		return nil, err
+	}
+	rows, err := db.Conn().Model(new(T)).Scopes(scope).
+		Clauses(clause.Select{Expression: clause.Expr{SQL: cols, Vars: colArgs}}).
+		Order(searchRankAlias + " DESC").Limit(opts.Limit).Offset(opts.Offset).Rows()
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rows.Close() }()
+
+	ids := make([]string, 0)
+	hits := make(map[string]*SearchResult[T])
+	for rows.Next() {
+		var id string
+		hit := &SearchResult[T]{}
+		dest := []any{&id, &hit.Rank}
+		hls := make([]string, 0)
+		if opts.Highlight {
+			hls = make([]string, len(idx.columns))
+			for i := range hls {
+				dest = append(dest, &hls[i])
+			}
+		}
+		if err := rows.Scan(dest...); err != nil {
+			return nil, err
+		}
+		if opts.Highlight {
+			hit.Highlights = make(map[string]string, len(hls))
+			for i, hl := range hls {
+				hit.Highlights[idx.columns[i]] = hl
+			}
+		}
+		ids = append(ids, id)
+		hits[id] = hit
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(ids) == 0 {
+		return page, nil
+	}
+
+	var models []T
+	if err := db.Conn().Where(fmt.Sprintf("%s IN ?", db.quote(idx.pk)), ids).Find(&models).Error; err != nil {
+		return nil, err
+	}
+	pk := sch.PrioritizedPrimaryField
+	for i := range models {
+		v, _ := pk.ValueOf(db.Conn().Statement.Context, reflect.ValueOf(&models[i]).Elem())
+		if hit, ok := hits[fmt.Sprint(v)]; ok {
+			hit.Model = &models[i]
+		}
+	}
+	for _, id := range ids {
+		if hit := hits[id]; hit.Model != nil {
+			page.Results = append(page.Results, *hit)
+		}
+	}
+	return page, nil
+}
@@ -0,0 +1,100 @@
+package gormutil_test
+
+import (
+	"errors"
+	"testing"
+
+	"gorm.io/gorm"
+
+	"github.com/avakarev/go-util/testutil"
+
+	"github.com/avakarev/go-util/gormutil"
+)
+
+type article struct {
+	gormutil.ModelBase
+	Title     string
+	Body      string
+	DeletedAt gorm.DeletedAt
+}
+
+func newArticle(t *testing.T, db *gormutil.DB, title string, body string) *article {
+	a := &article{Title: title, Body: body}
+	testutil.MustNoErr(a.GenerateID(), t)
+	testutil.MustNoErr(db.Create(a), t)
+	return a
+}
+
+func searchTitles(t *testing.T, db *gormutil.DB, query string, opts *gormutil.SearchOptions) ([]string, int64) {
+	page, err := gormutil.Search[article](db, query, opts)
+	testutil.MustNoErr(err, t)
+	titles := make([]string, 0, len(page.Results))
+	for _, r := range page.Results {
+		titles = append(titles, r.Model.Title)
+	}
+	return titles, page.Total
+}
+
+func TestSearch(t *testing.T) {
+	db := openDB(t)
+	testutil.MustNoErr(db.Conn().AutoMigrate(&article{}), t)
+
+	// rows inserted before index creation are indexed too
+	hello := newArticle(t, db, "hello world", "the world of gophers")
+	testutil.MustNoErr(db.RegisterSearchable(&article{}, "Title", "Body"), t)
+	testutil.MustNoErr(db.RegisterSearchable(&article{}, "Title", "Body"), t)
+	other := newArticle(t, db, "another story", "about the world and many other things around it")
+	newArticle(t, db, "unrelated", "nothing to see here")
+
+	titles, total := searchTitles(t, db, "world", nil)
+	testutil.Diff([]string{"hello world", "another story"}, titles, t)
+	testutil.Diff(int64(2), total, t)
+
+	page, err := gormutil.Search[article](db, "world", &gormutil.SearchOptions{Limit: 1, Offset: 1, Highlight: true})
+	testutil.MustNoErr(err, t)
+	testutil.Diff(int64(2), page.Total, t)
+	testutil.Diff(1, len(page.Results), t)
+	testutil.Diff(other.ID, page.Results[0].Model.ID, t)
+	testutil.Diff(map[string]string{
+		"title": "another story",
+		"body":  "about the <mark>world</mark> and many other things around it",
+	}, page.Results[0].Highlights, t)
+
+	// terms are matched literally unless query is raw
+	titles, _ = searchTitles(t, db, `world "of`, nil)
+	testutil.Diff([]string{"hello world"}, titles, t)
+	titles, _ = searchTitles(t, db, "goph*", &gormutil.SearchOptions{Raw: true})
+	testutil.Diff([]string{"hello world"}, titles, t)
+	titles, total = searchTitles(t, db, "  ", nil)
+	testutil.Diff([]string{}, titles, t)
+	testutil.Diff(int64(0), total, t)
+
+	// index follows updates and deletes
+	other.Body = "nothing"
+	testutil.MustNoErr(db.Update(other), t)
+	titles, total = searchTitles(t, db, "world", nil)
+	testutil.Diff([]string{"hello world"}, titles, t)
+	testutil.Diff(int64(1), total, t)
+	titles, _ = searchTitles(t, db, "nothing", nil)
+	testutil.Diff(2, len(titles), t)
+
+	testutil.MustNoErr(db.Delete(hello), t)
+	titles, total = searchTitles(t, db, "world", nil)
+	testutil.Diff([]string{}, titles, t)
+	testutil.Diff(int64(0), total, t)
+
+	testutil.MustNoErr(db.Conn().Unscoped().Delete(hello).Error, t)
+	var indexed int64
+	testutil.MustNoErr(db.Conn().Raw("SELECT count(*) FROM articles_fts WHERE articles_fts MATCH 'world'").Scan(&indexed).Error, t)
+	testutil.Diff(int64(0), indexed, t)
+}
+
+func TestSearchErrors(t *testing.T) {
+	db := openDB(t)
+	testutil.MustNoErr(db.Conn().AutoMigrate(&article{}), t)
+
+	testutil.MustErr(errors.New("searchable fields are required"), db.RegisterSearchable(&article{}), t)
+	testutil.MustErr(errors.New("model <*gormutil_test.article> doesn't have Author field"), db.RegisterSearchable(&article{}, "Author"), t)
+	_, err := gormutil.Search[article](db, "world", nil)
+	testutil.MustErr(errors.New(`table "articles" is not registered as searchable`), err, t)
+}
@@ -0,0 +1,387 @@
+package gormutil
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+
+	"github.com/avakarev/go-util/timeutil"
+)
+
+const (
+	defaultSnapshotPrefix   = "snapshot"
+	defaultSnapshotInterval = time.Hour
+	snapshotTimeLayout      = "20060102T150405Z"
+	snapshotExt             = ".db"
+)
+
+// ErrSnapshotUnsupported is returned when snapshots aren't supported by db dialect
+var ErrSnapshotUnsupported = errors.New("snapshots are supported by sqlite only")
+
+func (db *DB) isSQLite() bool {
+	return db.Conn().Dialector.Name() == "sqlite"
+}
+
+// Path returns file path of the main sqlite database
+func (db *DB) Path() (string, error) {
+	if !db.isSQLite() {
+		return "", ErrSnapshotUnsupported
+	}
+	var list []struct {
+		Seq  int
+		Name string
+		File string
+	}
+	if err := db.Conn().Raw("PRAGMA database_list").Scan(&list).Error; err != nil {
+		return "", err
+	}
+	for _, d := range list {
+		if d.Name == "main" && d.File != "" {
+			return d.File, nil
+		}
+	}
+	return "", errors.New("sqlite database is not backed by file")
+}
+
+// Snapshot writes transactionally consistent copy of the sqlite database into given path.
+// It's safe to be called while the database is in use.
+func (db *DB) Snapshot(path string) error {
+	if !db.isSQLite() {
+		return ErrSnapshotUnsupported
+	}
+	if _, err := os.Stat(path); err == nil {
+		return fmt.Errorf("snapshot %q already exists", path)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o750); err != nil {
+		return err
+	}
+	return db.Conn().Exec("VACUUM INTO ?", path).Error
+}
+
+func (db *DB) checkSnapshot(path string) error {
+	if _, err := os.Stat(path); err != nil {
+		return err
+	}
+	// attached database is visible to the current connection only
+	return db.Conn().Connection(func(tx *gorm.DB) error {
+		if err := tx.Exec("ATTACH DATABASE ? AS snapshot", path).Error; err != nil {
+			return err
+		}
+		defer tx.Exec("DETACH DATABASE snapshot")
+		var result string
+		if err := tx.Raw("PRAGMA snapshot.integrity_check(1)").Scan(&result).Error; err != nil {
+			return err
+		}
+		if result != "ok" {
+			return fmt.Errorf("snapshot %q is corrupted: %s", path, result)
+		}
+		return nil
+	})
+}
+
+func copyFile(src string, dst string) error {
+	in, err := os.Open(src) // #nosec
+	if err != nil {
+		return err
+	}
+	defer func() { _ = in.Close() }()
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600) // #nosec
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		_ = out.Close()
+		return err
+	}
+	if err := out.Sync(); err != nil {
+		_ = out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// sqliteSuffixes defines suffixes of the sqlite database's sidecar files
+var sqliteSuffixes = []string{"-wal", "-shm", "-journal"}
+
+// moveDB moves sqlite database file along with its existing sidecar files
+func moveDB(src string, dst string) error {
+	if err := os.Rename(src, dst); err != nil {
+		return err
+	}
+	for _, suffix := range sqliteSuffixes {
+		if err := os.Rename(src+suffix, dst+suffix); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	return nil
+}
+
+// removeDB removes sqlite database file along with its sidecar files
+func removeDB(path string) error {
+	for _, suffix := range append([]string{""}, sqliteSuffixes...) {
+		if err := os.Remove(path + suffix); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	return nil
+}
+
+// Restore replaces the sqlite database with given snapshot and reopens the connection.
+// Snapshot is checked for integrity before the database is touched.
+// If replacing fails, the original database is put back and reopened.
+// Conn blocks while the connection is swapped, but Restore must not be called while transactions
+// are in progress, queries running on the old connection fail once it's closed.
+func (db *DB) Restore(path string) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	dbPath, err := db.Path()
+	if err != nil {
+		return err
+	}
+	if err := db.checkSnapshot(path); err != nil {
+		return err
+	}
+
+	// copy next to the database first so that final rename is atomic
+	tmp := dbPath + ".restore"
+	if err := copyFile(path, tmp); err != nil {
+		_ = os.Remove(tmp)
+		return err
+	}
+	defer func() { _ = os.Remove(tmp) }()
+
+	db.connMu.Lock()
+	defer db.connMu.Unlock()
+
+	sqlDB, err := db.conn.DB()
+	if err != nil {
+		return err
+	}
+	if err := sqlDB.Close(); err != nil {
+		return err
+	}
+
+	// keep the original database aside until the snapshot is opened
+	orig := dbPath + ".orig"
+	if err := removeDB(orig); err != nil {
+		return db.reopen(err)
+	}
+	if err := moveDB(dbPath, orig); err != nil {
+		return db.reopen(err)
+	}
+	rollback := func(err error) error {
+		if rmErr := removeDB(dbPath); rmErr != nil {
+			return errors.Join(err, rmErr)
+		}
+		if mvErr := moveDB(orig, dbPath); mvErr != nil {
+			return errors.Join(err, mvErr)
+		}
+		return db.reopen(err)
+	}
+	if err := os.Rename(tmp, dbPath); err != nil {
+		return rollback(err)
+	}
+	conn, err := gorm.Open(db.dialector, db.config)
+	if err != nil {
+		return rollback(err)
+	}
+	db.conn = conn
+	_ = removeDB(orig)
+	return nil
+}
+
+// reopen reopens the database after failed restore and returns restore's error,
+// it must be called with connection lock held
+func (db *DB) reopen(err error) error {
+	conn, openErr := gorm.Open(db.dialector, db.config)
+	if openErr != nil {
+		return errors.Join(err, openErr)
+	}
+	db.conn = conn
+	return err
+}
+
+// SnapshotRetention defines how many snapshots are kept per period.
+// The newest snapshot of each hour/day is kept. Zero retention keeps all snapshots.
+type SnapshotRetention struct {
+	Hourly int
+	Daily  int
+}
+
+// Keep returns snapshot times to be kept, newest first
+func (r SnapshotRetention) Keep(times []time.Time) []time.Time {
+	sorted := slices.Clone(times)
+	slices.SortFunc(sorted, func(a, b time.Time) int {
+		return b.Compare(a)
+	})
+	if r.Hourly <= 0 && r.Daily <= 0 {
+		return sorted
+	}
+
+	hours := make(map[time.Time]struct{})
+	days := make(map[string]struct{})
+	keep := make([]time.Time, 0)
+	for _, t := range sorted {
+		t = t.UTC()
+		hour := t.Truncate(time.Hour)
+		day := t.Format(time.DateOnly)
+		kept := false
+		if _, ok := hours[hour]; !ok && len(hours) < r.Hourly {
+			hours[hour] = struct{}{}
+			kept = true
+		}
+		if _, ok := days[day]; !ok && len(days) < r.Daily {
+			days[day] = struct{}{}
+			kept = true
+		}
+		if kept {
+			keep = append(keep, t)
+		}
+	}
+	return keep
+}
+
+// SnapshotFile defines snapshot file
+type SnapshotFile struct {
+	Path string
+	Time time.Time
+}
+
+// SnapshotterConfig defines snapshotter settings
+type SnapshotterConfig struct {
+	// Dir is a directory snapshots are written to
+	Dir string
+	// Prefix is a snapshot file name prefix, defaults to "snapshot"
+	Prefix string
+	// Interval defines how often snapshots are taken, defaults to 1 hour
+	Interval time.Duration
+	// Retention defines how many snapshots are kept
+	Retention SnapshotRetention
+	// Clock defines time source, defaults to wall clock
+	Clock timeutil.Clock
+	// ErrHandler is executed when scheduled snapshot fails, defaults to gorm logger
+	ErrHandler func(err error)
+}
+
+// Snapshotter periodically snapshots the database and rotates old snapshots
+type Snapshotter struct {
+	timeutil.Timer
+	db     *DB
+	config SnapshotterConfig
+}
+
+func (s *Snapshotter) path(t time.Time) string {
+	return filepath.Join(s.config.Dir, s.config.Prefix+"-"+t.UTC().Format(snapshotTimeLayout)+snapshotExt)
+}
+
+// Snapshots returns list of existing snapshots, newest first
+func (s *Snapshotter) Snapshots() ([]SnapshotFile, error) {
+	entries, err := os.ReadDir(s.config.Dir)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	files := make([]SnapshotFile, 0)
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() || !strings.HasPrefix(name, s.config.Prefix+"-") || !strings.HasSuffix(name, snapshotExt) {
+			continue
+		}
+		ts := strings.TrimSuffix(strings.TrimPrefix(name, s.config.Prefix+"-"), snapshotExt)
+		t, err := time.Parse(snapshotTimeLayout, ts)
+		if err != nil {
+			continue
+		}
+		files = append(files, SnapshotFile{Path: filepath.Join(s.config.Dir, name), Time: t})
+	}
+	slices.SortFunc(files, func(a, b SnapshotFile) int {
+		return b.Time.Compare(a.Time)
+	})
+	return files, nil
+}
+
+// Latest returns the newest snapshot
+func (s *Snapshotter) Latest() (*SnapshotFile, error) {
+	files, err := s.Snapshots()
+	if err != nil {
+		return nil, err
+	}
+	if len(files) == 0 {
+		return nil, os.ErrNotExist
+	}
+	return &files[0], nil
+}
+
+// Rotate removes snapshots exceeding the retention
+func (s *Snapshotter) Rotate() error {
+	files, err := s.Snapshots()
+	if err != nil {
+		return err
+	}
+	times := make([]time.Time, len(files))
+	for i, f := range files {
+		times[i] = f.Time
+	}
+	keep := s.config.Retention.Keep(times)
+	for _, f := range files {
+		if slices.ContainsFunc(keep, f.Time.Equal) {
+			continue
+		}
+		if err := os.Remove(f.Path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Snapshot takes new snapshot and rotates old ones
+func (s *Snapshotter) Snapshot() (*SnapshotFile, error) {
+	f := SnapshotFile{Time: s.config.Clock.Now().UTC().Truncate(time.Second)}
+	f.Path = s.path(f.Time)
+	if err := s.db.Snapshot(f.Path); err != nil {
+		return nil, err
+	}
+	if err := s.Rotate(); err != nil {
+		return nil, err
+	}
+	return &f, nil
+}
+
+// NewSnapshotter returns new snapshotter value, it has to be started explicitly
+func (db *DB) NewSnapshotter(config SnapshotterConfig) *Snapshotter {
+	if config.Prefix == "" {
+		config.Prefix = defaultSnapshotPrefix
+	}
+	if config.Interval == 0 {
+		config.Interval = defaultSnapshotInterval
+	}
+	if config.Clock == nil {
+		config.Clock = timeutil.NewClock()
+	}
+	if config.ErrHandler == nil {
+		config.ErrHandler = func(err error) {
+			if db.config.Logger != nil {
+				db.config.Logger.Error(context.Background(), "failed to snapshot database, got error %v", err)
+			}
+		}
+	}
+	s := &Snapshotter{db: db, config: config}
+	s.Timer = timeutil.NewFixedTimer(config.Interval, func() {
+		if _, err := s.Snapshot(); err != nil {
+			s.config.ErrHandler(err)
+		}
+	})
+	return s
+}
@@ -0,0 +1,166 @@
+package gormutil_test
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/glebarez/sqlite"
+	"gorm.io/gorm"
+
+	"github.com/avakarev/go-util/testutil"
+	"github.com/avakarev/go-util/timeutil"
+
+	"github.com/avakarev/go-util/gormutil"
+)
+
+func TestSnapshotRetentionKeep(t *testing.T) {
+	at := func(s string) time.Time {
+		v, err := time.Parse(time.RFC3339, s)
+		testutil.MustNoErr(err, t)
+		return v
+	}
+	times := []time.Time{
+		at("2022-06-01T10:00:00Z"),
+		at("2022-06-02T10:00:00Z"),
+		at("2022-06-03T09:00:00Z"),
+		at("2022-06-03T10:00:00Z"),
+		at("2022-06-03T10:30:00Z"),
+		at("2022-06-03T11:00:00Z"),
+	}
+	cases := []struct {
+		retention gormutil.SnapshotRetention
+		keep      []time.Time
+	}{
+		{
+			retention: gormutil.SnapshotRetention{},
+			keep:      []time.Time{times[5], times[4], times[3], times[2], times[1], times[0]},
+		}, {
+			retention: gormutil.SnapshotRetention{Hourly: 2},
+			keep:      []time.Time{times[5], times[4]},
+		}, {
+			retention: gormutil.SnapshotRetention{Daily: 2},
+			keep:      []time.Time{times[5], times[1]},
+		}, {
+			retention: gormutil.SnapshotRetention{Hourly: 3, Daily: 3},
+			keep:      []time.Time{times[5], times[4], times[2], times[1], times[0]},
+		}}
+
+	for _, tt := range cases {
+		testutil.Diff(tt.keep, tt.retention.Keep(times), t)
+	}
+}
+
+func TestSnapshotter(t *testing.T) {
+	db := openDB(t)
+	testutil.MustNoErr(db.Conn().AutoMigrate(&article{}), t)
+	newArticle(t, db, "first", "")
+
+	clock := timeutil.NewMock()
+	clock.Set(time.Date(2022, time.June, 3, 10, 0, 0, 0, time.UTC))
+	dir := filepath.Join(t.TempDir(), "snapshots")
+	s := db.NewSnapshotter(gormutil.SnapshotterConfig{
+		Dir:       dir,
+		Retention: gormutil.SnapshotRetention{Hourly: 2},
+		Clock:     clock,
+	})
+
+	files, err := s.Snapshots()
+	testutil.MustNoErr(err, t)
+	testutil.Diff(0, len(files), t)
+	for range 3 {
+		_, err := s.Snapshot()
+		testutil.MustNoErr(err, t)
+		clock.Add(time.Hour)
+	}
+	_, err = s.Snapshot()
+	testutil.MustNoErr(err, t)
+	_, err = s.Snapshot()
+	testutil.MustErr(fmt.Errorf("snapshot %q already exists", filepath.Join(dir, "snapshot-20220603T130000Z.db")), err, t)
+
+	// files of other prefixes are ignored by rotation
+	testutil.MustNoErr(os.WriteFile(filepath.Join(dir, "other-20220603T090000Z.db"), nil, 0o600), t)
+	testutil.MustNoErr(s.Rotate(), t)
+	files, err = s.Snapshots()
+	testutil.MustNoErr(err, t)
+	testutil.Diff([]gormutil.SnapshotFile{
+		{Path: filepath.Join(dir, "snapshot-20220603T130000Z.db"), Time: time.Date(2022, time.June, 3, 13, 0, 0, 0, time.UTC)},
+		{Path: filepath.Join(dir, "snapshot-20220603T120000Z.db"), Time: time.Date(2022, time.June, 3, 12, 0, 0, 0, time.UTC)},
+	}, files, t)
+	entries, err := os.ReadDir(dir)
+	testutil.MustNoErr(err, t)
+	testutil.Diff(3, len(entries), t)
+
+	latest, err := s.Latest()
+	testutil.MustNoErr(err, t)
+	testutil.Diff(files[0], *latest, t)
+}
+
+func TestRestore(t *testing.T) {
+	db := openDB(t)
+	testutil.MustNoErr(db.Conn().AutoMigrate(&article{}), t)
+	first := newArticle(t, db, "first", "")
+	snapshot := filepath.Join(t.TempDir(), "snapshot.db")
+	testutil.MustNoErr(db.Snapshot(snapshot), t)
+	newArticle(t, db, "second", "")
+	testutil.Diff(int64(2), db.Count(&article{}), t)
+
+	testutil.MustNoErr(db.Restore(snapshot), t)
+	testutil.Diff(int64(1), db.Count(&article{}), t)
+	testutil.Diff("first", gormutil.First[article](db.Conn().Where("id = ?", first.ID)).Title, t)
+	newArticle(t, db, "third", "")
+
+	// database isn't touched if snapshot is missing or corrupted
+	err := db.Restore(filepath.Join(t.TempDir(), "missing.db"))
+	testutil.Diff(true, errors.Is(err, os.ErrNotExist), t)
+	corrupted := filepath.Join(t.TempDir(), "corrupted.db")
+	testutil.MustNoErr(os.WriteFile(corrupted, []byte("not a database"), 0o600), t)
+	testutil.Diff(true, db.Restore(corrupted) != nil, t)
+	testutil.Diff(int64(2), db.Count(&article{}), t)
+}
+
+// flakyDialector fails to open the database once, on given attempt
+type flakyDialector struct {
+	gorm.Dialector
+	opens  int
+	failAt int
+}
+
+func (d *flakyDialector) Initialize(db *gorm.DB) error {
+	d.opens++
+	if d.opens == d.failAt {
+		return errors.New("disk is gone")
+	}
+	return d.Dialector.Initialize(db)
+}
+
+func TestRestoreRollback(t *testing.T) {
+	dir := t.TempDir()
+	dialector := &flakyDialector{Dialector: sqlite.Open(filepath.Join(dir, "test.db")), failAt: 2}
+	db, err := gormutil.Open(dialector)
+	testutil.MustNoErr(err, t)
+	testutil.MustNoErr(db.Conn().Exec("CREATE TABLE notes (body text)").Error, t)
+	testutil.MustNoErr(db.Conn().Exec("INSERT INTO notes VALUES ('snapshot')").Error, t)
+	snapshot := filepath.Join(dir, "snapshot.db")
+	testutil.MustNoErr(db.Snapshot(snapshot), t)
+	testutil.MustNoErr(db.Conn().Exec("INSERT INTO notes VALUES ('latest')").Error, t)
+
+	testutil.MustErr(errors.New("disk is gone"), db.Restore(snapshot), t)
+	var notes []string
+	testutil.MustNoErr(db.Conn().Raw("SELECT body FROM notes").Scan(&notes).Error, t)
+	testutil.Diff([]string{"snapshot", "latest"}, notes, t)
+
+	testutil.MustNoErr(db.Restore(snapshot), t)
+	testutil.MustNoErr(db.Conn().Raw("SELECT body FROM notes").Scan(&notes).Error, t)
+	testutil.Diff([]string{"snapshot"}, notes, t)
+	entries, err := os.ReadDir(dir)
+	testutil.MustNoErr(err, t)
+	names := make([]string, 0, len(entries))
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	testutil.Diff([]string{"snapshot.db", "test.db"}, names, t)
+}
@@ -0,0 +1,43 @@
+package wsbridge
+
+import (
+	"sync"
+
+	"github.com/avakarev/go-util/wsutil"
+)
+
+// Peer implements embeddable peer that receives messages of followed topics only
+type Peer struct {
+	wsutil.BasePeer
+	mu     sync.RWMutex
+	topics map[string]struct{}
+}
+
+// Follow adds given topics to the peer's topics
+func (p *Peer) Follow(topics ...string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.topics == nil {
+		p.topics = make(map[string]struct{})
+	}
+	for _, t := range topics {
+		p.topics[t] = struct{}{}
+	}
+}
+
+// Unfollow removes given topics from the peer's topics
+func (p *Peer) Unfollow(topics ...string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, t := range topics {
+		delete(p.topics, t)
+	}
+}
+
+// Match checks whether given topic is followed by the peer
+func (p *Peer) Match(topic string) bool {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	_, ok := p.topics[topic]
+	return ok
+}
@@ -0,0 +1,189 @@
+// Package wsbridge broadcasts gormutil hooks to wsutil hub peers
+package wsbridge
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/google/uuid"
+
+	"github.com/avakarev/go-util/gormutil"
+	"github.com/avakarev/go-util/wsutil"
+)
+
+// ErrNotWatched is returned on subscribing to the table the bridge doesn't watch
+var ErrNotWatched = errors.New("table is not watched")
+
+// Broadcaster defines hub the changes are broadcasted to, e.g. *wsutil.BroadcastHub
+type Broadcaster interface {
+	BroadcastJSON(topic string, v any) error
+}
+
+// ProjectFunc projects changed model into the payload sent to peers
+type ProjectFunc func(model any) any
+
+// FilterFunc checks whether changed model matches the live query
+type FilterFunc func(model any) bool
+
+// Change defines payload broadcasted to peers
+type Change struct {
+	Query string `json:"query,omitempty"`
+	Table string `json:"table"`
+	Event string `json:"event"`
+	Data  any    `json:"data"`
+}
+
+// LiveQuery defines subscription to table changes narrowed by filter predicate
+type LiveQuery struct {
+	ID     string
+	Table  string
+	Filter FilterFunc
+}
+
+// Topic returns topic the live query changes are broadcasted to
+func (q *LiveQuery) Topic() string {
+	return q.Table + ".live." + q.ID
+}
+
+// Match checks whether given model matches the live query
+func (q *LiveQuery) Match(model any) bool {
+	return q.Filter == nil || q.Filter(model)
+}
+
+// Config defines bridge settings
+type Config struct {
+	// ErrorHandler is executed on broadcast error
+	ErrorHandler wsutil.ErrorHandler
+}
+
+// Bridge maps table hook events to hub topics
+type Bridge struct {
+	mu       sync.RWMutex
+	hub      Broadcaster
+	projects map[string]ProjectFunc
+	queries  map[string]*LiveQuery
+	config   Config
+}
+
+// Event returns short event name, e.g. "created" for "AfterCreate" hook event
+func Event(e gormutil.HookEvent) string {
+	switch {
+	case e.IsAfterCreate():
+		return "created"
+	case e.IsAfterUpdate():
+		return "updated"
+	case e.IsAfterDelete():
+		return "deleted"
+	}
+	return e.String()
+}
+
+// Topic returns topic of the table event, e.g. "users.created"
+func Topic(table string, e gormutil.HookEvent) string {
+	return table + "." + Event(e)
+}
+
+// Watch subscribes the bridge to create/update/delete hooks of given model.
+// Changed model is broadcasted as is unless project func is given.
+// Watching already watched table only replaces its project func.
+func (b *Bridge) Watch(db *gormutil.DB, model any, project ProjectFunc) {
+	table := gormutil.TableName(model)
+	b.mu.Lock()
+	_, watched := b.projects[table]
+	b.projects[table] = project
+	b.mu.Unlock()
+	if !watched {
+		db.SubscribeHook(model, b.Handle)
+	}
+}
+
+// Subscribe registers new live query over given model's table, the table has to be watched, see Watch
+func (b *Bridge) Subscribe(model any, filter FilterFunc) (*LiveQuery, error) {
+	q := &LiveQuery{
+		ID:     uuid.NewString(),
+		Table:  gormutil.TableName(model),
+		Filter: filter,
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, ok := b.projects[q.Table]; !ok {
+		return nil, fmt.Errorf("%w, table=%q", ErrNotWatched, q.Table)
+	}
+	b.queries[q.ID] = q
+	return q, nil
+}
+
+// Unsubscribe removes given live query
+func (b *Bridge) Unsubscribe(q *LiveQuery) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	delete(b.queries, q.ID)
+}
+
+// CountQueries returns number of registered live queries
+func (b *Bridge) CountQueries() int {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return len(b.queries)
+}
+
+func (b *Bridge) broadcast(topic string, change *Change) {
+	if err := b.hub.BroadcastJSON(topic, change); err != nil {
+		b.config.ErrorHandler(fmt.Errorf("on broadcast %q: %w", topic, err))
+	}
+}
+
+// Handle broadcasts given hook to the table topic and to the matching live queries
+func (b *Bridge) Handle(hook *gormutil.Hook) {
+	b.mu.RLock()
+	project := b.projects[hook.Table]
+	queries := make([]*LiveQuery, 0)
+	for _, q := range b.queries {
+		if q.Table == hook.Table {
+			queries = append(queries, q)
+		}
+	}
+	b.mu.RUnlock()
+
+	data := hook.Model
+	if project != nil {
+		data = project(hook.Model)
+	}
+
+	b.broadcast(Topic(hook.Table, hook.Event), &Change{
+		Table: hook.Table,
+		Event: Event(hook.Event),
+		Data:  data,
+	})
+	for _, q := range queries {
+		if !q.Match(hook.Model) {
+			continue
+		}
+		b.broadcast(q.Topic(), &Change{
+			Query: q.ID,
+			Table: hook.Table,
+			Event: Event(hook.Event),
+			Data:  data,
+		})
+	}
+}
+
+// New returns new bridge value
+func New(hub Broadcaster, config ...Config) *Bridge {
+	b := &Bridge{
+		hub:      hub,
+		projects: make(map[string]ProjectFunc),
+		queries:  make(map[string]*LiveQuery),
+	}
+
+	if len(config) > 0 {
+		b.config = config[0]
+	}
+
+	if b.config.ErrorHandler == nil {
+		b.config.ErrorHandler = wsutil.DefaultErrorHandler
+	}
+
+	return b
+}
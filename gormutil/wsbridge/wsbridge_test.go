@@ -0,0 +1,99 @@
+package wsbridge_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/avakarev/go-util/gormutil"
+	"github.com/avakarev/go-util/gormutil/wsbridge"
+	"github.com/avakarev/go-util/testutil"
+)
+
+type user struct {
+	Name  string
+	Admin bool
+}
+
+type event struct {
+	Topic  string
+	Change *wsbridge.Change
+}
+
+type hub chan event
+
+func (h hub) BroadcastJSON(topic string, v any) error {
+	h <- event{Topic: topic, Change: v.(*wsbridge.Change)}
+	return nil
+}
+
+func (h hub) next(t *testing.T) event {
+	select {
+	case e := <-h:
+		return e
+	case <-time.After(time.Second):
+		t.Fatal("no broadcast received")
+	}
+	return event{}
+}
+
+func TestTopic(t *testing.T) {
+	testutil.Diff("users.created", wsbridge.Topic("users", gormutil.HookAfterCreate), t)
+	testutil.Diff("users.updated", wsbridge.Topic("users", gormutil.HookAfterUpdate), t)
+	testutil.Diff("users.deleted", wsbridge.Topic("users", gormutil.HookAfterDelete), t)
+}
+
+func TestBridgeHandle(t *testing.T) {
+	h := make(hub, 8)
+	db := &gormutil.DB{}
+	db.WithHooks()
+
+	b := wsbridge.New(h)
+	_, err := b.Subscribe(&user{}, nil)
+	testutil.Diff(true, errors.Is(err, wsbridge.ErrNotWatched), t)
+
+	// watching table twice doesn't duplicate broadcasts
+	b.Watch(db, &user{}, nil)
+	b.Watch(db, &user{}, func(model any) any {
+		return model.(*user).Name
+	})
+	q, err := b.Subscribe(&user{}, func(model any) bool {
+		return model.(*user).Admin
+	})
+	testutil.MustNoErr(err, t)
+
+	db.AfterCreateHook(&user{Name: "foo"})
+	testutil.Diff(event{
+		Topic:  "users.created",
+		Change: &wsbridge.Change{Table: "users", Event: "created", Data: "foo"},
+	}, h.next(t), t)
+
+	db.AfterUpdateHook(&user{Name: "bar", Admin: true})
+	got := []event{h.next(t), h.next(t)}
+	testutil.Diff([]event{{
+		Topic:  "users.updated",
+		Change: &wsbridge.Change{Table: "users", Event: "updated", Data: "bar"},
+	}, {
+		Topic:  q.Topic(),
+		Change: &wsbridge.Change{Query: q.ID, Table: "users", Event: "updated", Data: "bar"},
+	}}, got, t)
+
+	select {
+	case e := <-h:
+		t.Errorf("unexpected broadcast: %v", e)
+	default:
+	}
+
+	b.Unsubscribe(q)
+	testutil.Diff(0, b.CountQueries(), t)
+}
+
+func TestPeerMatch(t *testing.T) {
+	p := &wsbridge.Peer{}
+	testutil.Diff(false, p.Match("users.created"), t)
+	p.Follow("users.created", "users.deleted")
+	testutil.Diff(true, p.Match("users.created"), t)
+	p.Unfollow("users.created")
+	testutil.Diff(false, p.Match("users.created"), t)
+	testutil.Diff(true, p.Match("users.deleted"), t)
+}
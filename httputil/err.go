@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"net/http"
 	"os"
+	"reflect"
 	"strings"
 
 	"github.com/avakarev/go-util/strutil"
@@ -26,6 +27,11 @@ type Err struct {
 	Items []ValidationErr `json:"items,omitempty"`
 }
 
+// Error returns error's string representation, so that Err can be returned as error
+func (e *Err) Error() string {
+	return fmt.Sprintf("%d: %s", e.Code, e.Msg)
+}
+
 // ErrResponse represents json container for error object
 type ErrResponse struct {
 	Error Err `json:"error"`
@@ -113,6 +119,20 @@ func NewErr(code int, msg string) *ErrResponse {
 	}
 }
 
+// NewValidator returns struct validator whose field names follow json tags,
+// so that validation error subjects match payload fields, see NewValidationErr
+func NewValidator() *validator.Validate {
+	v := validator.New()
+	v.RegisterTagNameFunc(func(fld reflect.StructField) string {
+		name := strings.SplitN(fld.Tag.Get("json"), ",", 2)[0]
+		if name == "-" {
+			return ""
+		}
+		return name
+	})
+	return v
+}
+
 // NewValidationErr returns new validation error value
 func NewValidationErr(errors validator.ValidationErrors) *ErrResponse {
 	err := NewErr(http.StatusBadRequest, "validation error")
@@ -128,6 +148,11 @@ func NewValidationErr(errors validator.ValidationErrors) *ErrResponse {
 
 // NewErrFrom returns new error value from given error
 func NewErrFrom(err error) *ErrResponse {
+	var e *Err
+	if errors.As(err, &e) {
+		return &ErrResponse{Error: *e}
+	}
+
 	var ve validator.ValidationErrors
 	if errors.As(err, &ve) {
 		return NewValidationErr(ve)
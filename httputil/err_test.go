@@ -2,8 +2,8 @@ package httputil_test
 
 import (
 	"errors"
-	"reflect"
-	"strings"
+	"fmt"
+	"os"
 	"testing"
 
 	"github.com/go-playground/validator/v10"
@@ -19,14 +19,7 @@ func TestNewValidationErr(t *testing.T) {
 		Some string `validate:"required"`
 	}
 
-	validate := validator.New()
-	validate.RegisterTagNameFunc(func(fld reflect.StructField) string {
-		name := strings.SplitN(fld.Tag.Get("json"), ",", 2)[0]
-		if name == "-" {
-			return ""
-		}
-		return name
-	})
+	validate := httputil.NewValidator()
 
 	var ve validator.ValidationErrors
 	testutil.Diff(true, errors.As(validate.Struct(&model{}), &ve), t)
@@ -44,3 +37,29 @@ func TestNewValidationErr(t *testing.T) {
 		},
 	}, resp, t)
 }
+
+func TestNewErrFrom(t *testing.T) {
+	cases := []struct {
+		err  error
+		resp *httputil.ErrResponse
+	}{
+		{
+			err:  errors.New("boom"),
+			resp: httputil.NewErr(500, "boom"),
+		}, {
+			err:  os.ErrNotExist,
+			resp: httputil.NewErr(404, ""),
+		}, {
+			err:  fmt.Errorf("on create: %w", &httputil.NewErr(409, "already exists").Error),
+			resp: httputil.NewErr(409, "already exists"),
+		}}
+
+	for _, tt := range cases {
+		testutil.Diff(tt.resp, httputil.NewErrFrom(tt.err), t)
+	}
+}
+
+func TestErrError(t *testing.T) {
+	err := &httputil.Err{Code: 404, Msg: "not found"}
+	testutil.Diff("404: not found", err.Error(), t)
+}
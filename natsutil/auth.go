@@ -0,0 +1,108 @@
+package natsutil
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/nats-io/nats.go"
+
+	"github.com/avakarev/go-util/envutil"
+)
+
+// TLSConfig defines TLS settings, client certificate and key enable mutual TLS
+type TLSConfig struct {
+	CAFile   string
+	CertFile string
+	KeyFile  string
+}
+
+// servers returns comma separated list of server urls
+func (config *ConnConfig) servers() string {
+	urls := make([]string, 0, len(config.URLs)+1)
+	for _, u := range append([]string{config.URL}, config.URLs...) {
+		if u = strings.TrimSpace(u); u != "" {
+			urls = append(urls, u)
+		}
+	}
+	return strings.Join(urls, ",")
+}
+
+// authOptions returns connection options of the configured authentication method and TLS.
+// It fails if more than one authentication method is configured.
+func (config *ConnConfig) authOptions() ([]nats.Option, error) {
+	opts := make([]nats.Option, 0)
+	methods := make([]string, 0)
+	if config.User != "" || config.Password != "" {
+		methods = append(methods, "user")
+		opts = append(opts, nats.UserInfo(config.User, config.Password))
+	}
+	if config.Token != "" {
+		methods = append(methods, "token")
+		opts = append(opts, nats.Token(config.Token))
+	}
+	if config.NKeySeedFile != "" {
+		methods = append(methods, "nkey")
+		opt, err := nats.NkeyOptionFromSeed(config.NKeySeedFile)
+		if err != nil {
+			return nil, fmt.Errorf("nats: %w", err)
+		}
+		opts = append(opts, opt)
+	}
+	if config.CredsFile != "" {
+		methods = append(methods, "creds")
+		opts = append(opts, nats.UserCredentials(config.CredsFile))
+	}
+	if len(methods) > 1 {
+		return nil, fmt.Errorf("nats: only one auth method is allowed, got %s", strings.Join(methods, ", "))
+	}
+
+	if tls := config.TLS; tls != nil {
+		if tls.CAFile != "" {
+			opts = append(opts, nats.RootCAs(tls.CAFile))
+		}
+		if (tls.CertFile == "") != (tls.KeyFile == "") {
+			return nil, errors.New("nats: both tls cert and key are required")
+		}
+		if tls.CertFile != "" {
+			opts = append(opts, nats.ClientCert(tls.CertFile, tls.KeyFile))
+		}
+	}
+	return opts, nil
+}
+
+// ConfigFromEnv returns connection config initialized from env variables:
+// NATS_URL (required, comma separated for clusters), NATS_USER and NATS_PASSWORD, NATS_TOKEN,
+// NATS_NKEY_SEED (seed file), NATS_CREDS (credentials file), NATS_CA, NATS_CERT and NATS_KEY.
+func ConfigFromEnv() (*ConnConfig, error) {
+	env, err := envutil.NewAppEnv()
+	if err != nil {
+		return nil, err
+	}
+	urls, err := envutil.ShouldStrSlice("NATS_URL", ",")
+	if err != nil {
+		return nil, err
+	}
+	config := &ConnConfig{
+		Env:          env,
+		URL:          urls[0],
+		URLs:         urls[1:],
+		User:         envutil.Str("NATS_USER"),
+		Password:     envutil.Str("NATS_PASSWORD"),
+		Token:        envutil.Str("NATS_TOKEN"),
+		NKeySeedFile: envutil.Str("NATS_NKEY_SEED"),
+		CredsFile:    envutil.Str("NATS_CREDS"),
+	}
+	tls := &TLSConfig{
+		CAFile:   envutil.Str("NATS_CA"),
+		CertFile: envutil.Str("NATS_CERT"),
+		KeyFile:  envutil.Str("NATS_KEY"),
+	}
+	if *tls != (TLSConfig{}) {
+		config.TLS = tls
+	}
+	if _, err := config.authOptions(); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
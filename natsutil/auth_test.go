@@ -0,0 +1,55 @@
+package natsutil_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/avakarev/go-util/natsutil"
+	"github.com/avakarev/go-util/testutil"
+)
+
+func TestConfigFromEnv(t *testing.T) {
+	resetEnv := testutil.SetEnv(testutil.Env{
+		"APP_ENV":    "dev",
+		"NATS_URL":   "nats://a:4222,nats://b:4222",
+		"NATS_TOKEN": "s3cr3t",
+		"NATS_CA":    "/etc/nats/ca.pem",
+		"NATS_CERT":  "/etc/nats/cert.pem",
+		"NATS_KEY":   "/etc/nats/key.pem",
+	})
+	defer resetEnv()
+
+	config, err := natsutil.ConfigFromEnv()
+	testutil.MustNoErr(err, t)
+	testutil.Diff("dev", config.Env.String(), t)
+	testutil.Diff("nats://a:4222", config.URL, t)
+	testutil.Diff([]string{"nats://b:4222"}, config.URLs, t)
+	testutil.Diff("s3cr3t", config.Token, t)
+	testutil.Diff(&natsutil.TLSConfig{
+		CAFile:   "/etc/nats/ca.pem",
+		CertFile: "/etc/nats/cert.pem",
+		KeyFile:  "/etc/nats/key.pem",
+	}, config.TLS, t)
+}
+
+func TestConfigFromEnvAuthConflict(t *testing.T) {
+	resetEnv := testutil.SetEnv(testutil.Env{
+		"APP_ENV":       "dev",
+		"NATS_URL":      "nats://a:4222",
+		"NATS_USER":     "app",
+		"NATS_PASSWORD": "pass",
+		"NATS_CREDS":    "/etc/nats/app.creds",
+	})
+	defer resetEnv()
+
+	_, err := natsutil.ConfigFromEnv()
+	testutil.MustErr(errors.New("nats: only one auth method is allowed, got user, creds"), err, t)
+}
+
+func TestNewConnTLSConfig(t *testing.T) {
+	_, err := natsutil.NewConn(&natsutil.ConnConfig{
+		URL: "nats://127.0.0.1:4222",
+		TLS: &natsutil.TLSConfig{CertFile: "/etc/nats/cert.pem"},
+	})
+	testutil.MustErr(errors.New("nats: both tls cert and key are required"), err, t)
+}
@@ -0,0 +1,39 @@
+package natsutil
+
+import (
+	"context"
+
+	"github.com/nats-io/nats.go"
+)
+
+// Bus defines messaging operations of the connection, see Conn and natstest.FakeBus
+type Bus interface {
+	// ClientConfig returns bus's client config with given options applied
+	ClientConfig(opts ...ClientOption) *ClientConfig
+	// Subject returns given request subject namespaced by the bus with given options applied
+	Subject(subj string, opts ...ClientOption) string
+	// Use appends given middlewares to the chain of handlers subscribed afterwards
+	Use(mws ...Middleware)
+	// Publish sends byte slice to the given subject
+	Publish(subj string, data []byte) error
+	// PublishJSON marshals given value into JSON and sends to the given subject
+	PublishJSON(subj string, v any) error
+	// PublishEncoded encodes given value with client config's codec and sends to the given subject
+	PublishEncoded(ctx context.Context, subj string, v any, opts ...ClientOption) error
+	// PublishMsg sends given message, propagating request id and trace context via headers
+	PublishMsg(ctx context.Context, msg *nats.Msg) error
+	// Subscribe subscribes given handler to the given subject
+	Subscribe(subj string, fn MsgHandlerFunc, mws ...Middleware) error
+	// SubscribeContext subscribes given context-aware handler to the given subject
+	SubscribeContext(subj string, fn MsgContextHandlerFunc, mws ...Middleware) error
+	// QueueSubscribe subscribes given handler to the given subject as a member of the queue group
+	QueueSubscribe(subj string, queue string, fn nats.MsgHandler, mws ...Middleware) error
+	// QueueSubscribeContext subscribes given context-aware handler to the given subject as a member of the queue group
+	QueueSubscribeContext(subj string, queue string, fn MsgContextHandlerFunc, mws ...Middleware) error
+	// RequestContext sends request and returns reply's message
+	RequestContext(ctx context.Context, subj string, v any, opts ...ClientOption) (*nats.Msg, error)
+	// RequestMsg sends given message as request and returns reply's message
+	RequestMsg(ctx context.Context, msg *nats.Msg, opts ...ClientOption) (*nats.Msg, error)
+}
+
+var _ Bus = (*Conn)(nil)
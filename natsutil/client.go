@@ -10,16 +10,30 @@ import (
 type ClientConfig struct {
 	Env     string
 	Timeout time.Duration
+	// Codec defines encoding of request payloads, defaults to JSONCodec
+	Codec Codec
+	// Idempotent marks request as safe to repeat, e.g. to retry or hedge it, see ResilientClient
+	Idempotent bool
 }
 
 // Copy returns config copy
 func (config *ClientConfig) Copy() *ClientConfig {
 	return &ClientConfig{
-		Env:     config.Env,
-		Timeout: config.Timeout,
+		Env:        config.Env,
+		Timeout:    config.Timeout,
+		Codec:      config.Codec,
+		Idempotent: config.Idempotent,
 	}
 }
 
+// PayloadCodec returns config's codec, JSONCodec if it isn't set
+func (config *ClientConfig) PayloadCodec() Codec {
+	if config.Codec == nil {
+		return JSONCodec
+	}
+	return config.Codec
+}
+
 // ClientOption defines client configuring func type
 type ClientOption func(config *ClientConfig)
 
@@ -49,10 +63,25 @@ func WithTimeout(timeout time.Duration) ClientOption {
 	}
 }
 
+// WithCodec sets request payloads codec to given value
+func WithCodec(codec Codec) ClientOption {
+	return func(config *ClientConfig) {
+		config.Codec = codec
+	}
+}
+
+// WithIdempotent marks request as safe to repeat
+func WithIdempotent() ClientOption {
+	return func(config *ClientConfig) {
+		config.Idempotent = true
+	}
+}
+
 // DefaultClientConfig returns config with default values
 func DefaultClientConfig() *ClientConfig {
 	return &ClientConfig{
 		Env:     envutil.EnvProd,
-		Timeout: 8 * time.Second,
+		Timeout: defaultTimeout,
+		Codec:   JSONCodec,
 	}
 }
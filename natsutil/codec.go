@@ -0,0 +1,151 @@
+package natsutil
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/nats-io/nats.go"
+	"github.com/vmihailenco/msgpack/v5"
+
+	"github.com/avakarev/go-util/httputil"
+)
+
+// ContentTypeHeader is a header carrying content type of the encoded payload
+const ContentTypeHeader = "Content-Type"
+
+// Codec defines payload encoding
+type Codec interface {
+	ContentType() string
+	Marshal(v any) ([]byte, error)
+	Unmarshal(data []byte, v any) error
+}
+
+var (
+	// JSONCodec encodes payloads as JSON, it's the default one
+	JSONCodec Codec = jsonCodec{}
+	// MsgpackCodec encodes payloads as MessagePack, struct fields are named after their json tags
+	MsgpackCodec Codec = msgpackCodec{}
+	// GobCodec encodes payloads with encoding/gob, it suits Go-only peers
+	GobCodec Codec = gobCodec{}
+)
+
+type jsonCodec struct{}
+
+func (jsonCodec) ContentType() string                { return "application/json" }
+func (jsonCodec) Marshal(v any) ([]byte, error)      { return json.Marshal(v) }
+func (jsonCodec) Unmarshal(data []byte, v any) error { return json.Unmarshal(data, v) }
+
+type msgpackCodec struct{}
+
+func (msgpackCodec) ContentType() string { return "application/msgpack" }
+
+func (msgpackCodec) Marshal(v any) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := msgpack.NewEncoder(&buf)
+	enc.SetCustomStructTag("json")
+	enc.UseCompactInts(true)
+	if err := enc.Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (msgpackCodec) Unmarshal(data []byte, v any) error {
+	dec := msgpack.NewDecoder(bytes.NewReader(data))
+	dec.SetCustomStructTag("json")
+	return dec.Decode(v)
+}
+
+type gobCodec struct{}
+
+func (gobCodec) ContentType() string { return "application/x-gob" }
+
+func (gobCodec) Marshal(v any) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (gobCodec) Unmarshal(data []byte, v any) error {
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(v)
+}
+
+var (
+	codecsMu sync.RWMutex
+	codecs   = map[string]Codec{
+		JSONCodec.ContentType():    JSONCodec,
+		MsgpackCodec.ContentType(): MsgpackCodec,
+		GobCodec.ContentType():     GobCodec,
+	}
+)
+
+// RegisterCodec registers given codec by its content type, replacing already registered one
+func RegisterCodec(codec Codec) {
+	codecsMu.Lock()
+	defer codecsMu.Unlock()
+	codecs[codec.ContentType()] = codec
+}
+
+// CodecFor returns codec registered for given content type, parameters like "; charset=utf-8" are ignored.
+// Unknown content type is reported as *httputil.Err with 415 code.
+func CodecFor(contentType string) (Codec, error) {
+	mediaType, _, _ := strings.Cut(contentType, ";")
+	codecsMu.RLock()
+	codec, ok := codecs[strings.ToLower(strings.TrimSpace(mediaType))]
+	codecsMu.RUnlock()
+	if !ok {
+		return nil, &httputil.NewErr(http.StatusUnsupportedMediaType, fmt.Sprintf("unsupported content type %q", contentType)).Error
+	}
+	return codec, nil
+}
+
+// MsgCodec returns codec of the given message by its content type header, JSONCodec if header is missing
+func MsgCodec(msg *nats.Msg) (Codec, error) {
+	if msg.Header == nil || msg.Header.Get(ContentTypeHeader) == "" {
+		return JSONCodec, nil
+	}
+	return CodecFor(msg.Header.Get(ContentTypeHeader))
+}
+
+// EncodeMsg encodes given value into message's data with given codec and sets content type header
+func EncodeMsg(msg *nats.Msg, codec Codec, v any) error {
+	data, err := codec.Marshal(v)
+	if err != nil {
+		return err
+	}
+	if msg.Header == nil {
+		msg.Header = make(nats.Header)
+	}
+	msg.Header.Set(ContentTypeHeader, codec.ContentType())
+	msg.Data = data
+	return nil
+}
+
+// DecodeMsg decodes message's data into given pointer destination with codec of its content type
+func DecodeMsg(msg *nats.Msg, destPtr any) error {
+	codec, err := MsgCodec(msg)
+	if err != nil {
+		return err
+	}
+	return codec.Unmarshal(msg.Data, destPtr)
+}
+
+// RespondEncoded responds given value encoded with codec of the request
+func RespondEncoded(msg *nats.Msg, v any) error {
+	codec, err := MsgCodec(msg)
+	if err != nil {
+		return err
+	}
+	reply := nats.NewMsg(msg.Reply)
+	if err := EncodeMsg(reply, codec, v); err != nil {
+		return err
+	}
+	return RespondMsg(msg, reply)
+}
@@ -0,0 +1,63 @@
+package natsutil_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/nats-io/nats.go"
+
+	"github.com/avakarev/go-util/natsutil"
+	"github.com/avakarev/go-util/natsutil/natstest"
+	"github.com/avakarev/go-util/testutil"
+)
+
+func TestCodecs(t *testing.T) {
+	for _, codec := range []natsutil.Codec{natsutil.JSONCodec, natsutil.MsgpackCodec, natsutil.GobCodec} {
+		t.Run(codec.ContentType(), func(t *testing.T) {
+			msg := nats.NewMsg("orders.created")
+			testutil.MustNoErr(natsutil.EncodeMsg(msg, codec, order{ID: "1", Total: 42}), t)
+			testutil.Diff(codec.ContentType(), msg.Header.Get(natsutil.ContentTypeHeader), t)
+
+			var got order
+			testutil.MustNoErr(natsutil.DecodeMsg(msg, &got), t)
+			testutil.Diff(order{ID: "1", Total: 42}, got, t)
+		})
+	}
+}
+
+func TestCodecFor(t *testing.T) {
+	codec, err := natsutil.CodecFor("application/json; charset=utf-8")
+	testutil.MustNoErr(err, t)
+	testutil.Diff("application/json", codec.ContentType(), t)
+
+	_, err = natsutil.CodecFor("text/csv")
+	testutil.MustErr(errors.New(`415: unsupported content type "text/csv"`), err, t)
+
+	// missing header means JSON
+	codec, err = natsutil.MsgCodec(&nats.Msg{Data: []byte(`{}`)})
+	testutil.MustNoErr(err, t)
+	testutil.Diff("application/json", codec.ContentType(), t)
+}
+
+func TestMixedCodecs(t *testing.T) {
+	bus := natstest.NewFakeBus("dev")
+	testutil.MustNoErr(natsutil.Handle(bus, "orders.total", func(ctx context.Context, o order) (order, error) {
+		o.Total *= 2
+		return o, nil
+	}), t)
+
+	resp, err := natsutil.Call[order, order](bus, "orders.total", order{ID: "1", Total: 2})
+	testutil.MustNoErr(err, t)
+	testutil.Diff(order{ID: "1", Total: 4}, resp, t)
+
+	msg, err := bus.RequestContext(context.Background(), "orders.total", order{ID: "2", Total: 3}, natsutil.WithCodec(natsutil.MsgpackCodec))
+	testutil.MustNoErr(err, t)
+	testutil.Diff("application/msgpack", msg.Header.Get(natsutil.ContentTypeHeader), t)
+	var got order
+	testutil.MustNoErr(natsutil.DecodeMsg(msg, &got), t)
+	testutil.Diff(order{ID: "2", Total: 6}, got, t)
+
+	testutil.MustNoErr(bus.PublishEncoded(context.Background(), "telemetry", order{ID: "3"}, natsutil.WithCodec(natsutil.GobCodec)), t)
+	bus.MustPublished("telemetry", order{ID: "3"}, t)
+}
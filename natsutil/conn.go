@@ -1,12 +1,14 @@
 package natsutil
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
-	"strings"
+	"sync"
 	"time"
 
 	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
 	"github.com/rs/zerolog/log"
 
 	"github.com/avakarev/go-util/envutil"
@@ -15,14 +17,29 @@ import (
 const (
 	defaultMaxReconnect  = 60
 	defaultReconnectWait = 5 * time.Second
+	defaultNakDelay      = 5 * time.Second
+	defaultTimeout       = 8 * time.Second
 )
 
 // Conn implements nats connection
 type Conn struct {
-	env           envutil.AppEnv
-	conn          *nats.Conn
-	subscriptions map[*nats.Subscription]struct{}
-	ErrHandler    ErrHandlerFunc
+	env            envutil.AppEnv
+	namer          SubjectNamer
+	conn           *nats.Conn
+	js             jetstream.JetStream
+	subsMu         sync.Mutex // guards subscriptions and consumers
+	subscriptions  map[*nats.Subscription]struct{}
+	consumers      map[jetstream.ConsumeContext]struct{}
+	nakDelay       time.Duration
+	client         *ClientConfig
+	handlerTimeout time.Duration
+	ctx            context.Context
+	cancel         context.CancelFunc
+	closed         chan struct{}
+	middlewares    []Middleware
+	lockerMu       sync.Mutex
+	locker         *Locker
+	ErrHandler     ErrHandlerFunc
 }
 
 // Env returns current app env
@@ -30,13 +47,21 @@ func (c *Conn) Env() envutil.AppEnv {
 	return c.env
 }
 
-func (c *Conn) enrichSubj(subj string) string {
-	if strings.HasPrefix(subj, envutil.EnvDev) ||
-		strings.HasPrefix(subj, envutil.EnvBeta) ||
-		strings.HasPrefix(subj, envutil.EnvProd) {
-		return subj
+func (c *Conn) subjectNamer() SubjectNamer {
+	if c.namer == nil {
+		return DefaultNamer
 	}
-	return fmt.Sprintf("%s.%s", c.env.String(), subj)
+	return c.namer
+}
+
+// enrichSubj namespaces given subject with current env, see SubjectNamer
+func (c *Conn) enrichSubj(subj string) string {
+	return NamespaceSubject(c.subjectNamer(), c.env.String(), subj)
+}
+
+// enrichName namespaces jetstream stream/bucket name with current env, e.g. "dev_orders"
+func (c *Conn) enrichName(name string) string {
+	return NamespaceName(c.subjectNamer(), c.env.String(), name)
 }
 
 // Publish sends byte slice to the given subject
@@ -57,75 +82,196 @@ func (c *Conn) PublishJSON(subj string, data any) error {
 	return c.Publish(subj, bytes)
 }
 
-// Subscribe subscribes given handler to the given subject
-func (c *Conn) Subscribe(subj string, fn MsgHandlerFunc) error {
-	subj = c.enrichSubj(subj)
-	sub, err := c.conn.Subscribe(subj, func(msg *nats.Msg) {
-		if err := fn(msg); err != nil {
+// PublishEncoded encodes given value with client config's codec and sends to the given subject,
+// codec is advertised via ContentTypeHeader
+func (c *Conn) PublishEncoded(ctx context.Context, subj string, v any, opts ...ClientOption) error {
+	msg := nats.NewMsg(subj)
+	if err := EncodeMsg(msg, c.ClientConfig(opts...).PayloadCodec(), v); err != nil {
+		return err
+	}
+	return c.PublishMsg(ctx, msg)
+}
+
+// handlerCtx returns per-message handler context with deadline
+func (c *Conn) handlerCtx() (context.Context, context.CancelFunc) {
+	// zero connection value has neither base context nor timeout, e.g. in tests
+	if c.ctx == nil {
+		return context.WithCancel(context.Background())
+	}
+	return context.WithTimeout(c.ctx, c.handlerTimeout)
+}
+
+func (c *Conn) msgHandler(fn MsgContextHandlerFunc, mws []Middleware) nats.MsgHandler {
+	fn = c.chain(fn, mws)
+	return func(msg *nats.Msg) {
+		ctx, cancel := c.handlerCtx()
+		defer cancel()
+		ctx = ContextFromHeader(ctx, msg.Header)
+		if err := fn(ctx, msg); err != nil {
 			c.ErrHandler(msg, err)
 		}
-	})
-	if err != nil {
-		return fmt.Errorf("%w, subj=%q", err, subj)
 	}
-	log.Debug().Str("subject", sub.Subject).Msg("nats: subscribed")
-	c.subscriptions[sub] = struct{}{}
-	return nil
 }
 
-// QueueSubscribe subscribes given handler to the given subject
-func (c *Conn) QueueSubscribe(subj string, queue string, fn nats.MsgHandler) error {
+func (c *Conn) subscribe(subj string, queue string, fn nats.MsgHandler) (*nats.Subscription, error) {
 	subj = c.enrichSubj(subj)
 	sub, err := c.conn.QueueSubscribe(subj, queue, fn)
 	if err != nil {
-		return fmt.Errorf("%w, subj=%q", err, subj)
+		return nil, fmt.Errorf("%w, subj=%q", err, subj)
 	}
-	log.Debug().Str("subject", sub.Subject).Str("queue", queue).Msg("nats: subscribed")
+	e := log.Debug().Str("subject", sub.Subject)
+	if queue != "" {
+		e = e.Str("queue", queue)
+	}
+	e.Msg("nats: subscribed")
+	c.subsMu.Lock()
 	c.subscriptions[sub] = struct{}{}
+	c.subsMu.Unlock()
+	return sub, nil
+}
+
+// Subscribe subscribes given handler to the given subject.
+// Handler is wrapped with connection's and given middlewares.
+func (c *Conn) Subscribe(subj string, fn MsgHandlerFunc, mws ...Middleware) error {
+	return c.SubscribeContext(subj, fn.withContext(), mws...)
+}
+
+// SubscribeContext subscribes given handler to the given subject,
+// handler receives per-message context which is cancelled on timeout or connection close
+func (c *Conn) SubscribeContext(subj string, fn MsgContextHandlerFunc, mws ...Middleware) error {
+	_, err := c.subscribe(subj, "", c.msgHandler(fn, mws))
+	return err
+}
+
+// QueueSubscribe subscribes given handler to the given subject as a member of the queue group.
+// Handler is wrapped with connection's and given middlewares.
+func (c *Conn) QueueSubscribe(subj string, queue string, fn nats.MsgHandler, mws ...Middleware) error {
+	return c.QueueSubscribeContext(subj, queue, func(_ context.Context, msg *nats.Msg) error {
+		fn(msg)
+		return nil
+	}, mws...)
+}
+
+// QueueSubscribeContext subscribes given handler to the given subject as a member of the queue group,
+// handler receives per-message context which is cancelled on timeout or connection close
+func (c *Conn) QueueSubscribeContext(subj string, queue string, fn MsgContextHandlerFunc, mws ...Middleware) error {
+	_, err := c.subscribe(subj, queue, c.msgHandler(fn, mws))
+	return err
+}
+
+// ClientConfig returns connection's client config with given options applied
+func (c *Conn) ClientConfig(opts ...ClientOption) *ClientConfig {
+	return ClientConfigure(c.client, opts)
+}
+
+// Subject returns given request subject namespaced with client config's env, see SubjectNamer
+func (c *Conn) Subject(subj string, opts ...ClientOption) string {
+	return NamespaceSubject(c.subjectNamer(), c.ClientConfig(opts...).Env, subj)
+}
+
+// PublishMsg sends given message, request id and trace context of given context are propagated via headers
+func (c *Conn) PublishMsg(ctx context.Context, msg *nats.Msg) error {
+	msg.Subject = c.enrichSubj(msg.Subject)
+	if msg.Header == nil {
+		msg.Header = make(nats.Header)
+	}
+	InjectHeader(ctx, msg.Header)
+	if err := c.conn.PublishMsg(msg); err != nil {
+		return fmt.Errorf("%w, subj=%q", err, msg.Subject)
+	}
 	return nil
 }
 
-func (c *Conn) req(subj string, data []byte, timeout time.Duration) (*nats.Msg, error) {
-	subj = c.enrichSubj(subj)
-	msg, err := c.conn.Request(subj, data, timeout)
+// RequestMsg sends given message as request and returns reply's message.
+// Request id and trace context of given context are propagated via headers.
+// Client config's timeout is applied unless given context already has deadline.
+// Error reply is returned as *httputil.Err error.
+func (c *Conn) RequestMsg(ctx context.Context, msg *nats.Msg, opts ...ClientOption) (*nats.Msg, error) {
+	config := c.ClientConfig(opts...)
+	msg.Subject = c.Subject(msg.Subject, opts...)
+	if msg.Header == nil {
+		msg.Header = make(nats.Header)
+	}
+	InjectHeader(ctx, msg.Header)
+	if _, ok := ctx.Deadline(); !ok && config.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, config.Timeout)
+		defer cancel()
+	}
+	resp, err := c.conn.RequestMsgWithContext(ctx, msg)
 	if err != nil {
-		return nil, fmt.Errorf("%w, subj=%q", err, subj)
+		return nil, fmt.Errorf("%w, subj=%q", TransportErr(err), msg.Subject)
+	}
+	if err := ReplyErr(resp); err != nil {
+		return nil, err
 	}
-	return msg, nil
+	return resp, nil
 }
 
-// Request sends request and returns reply's message
-func (c *Conn) Request(subj string, v any, timeout time.Duration) (*nats.Msg, error) {
-	if v != nil {
-		dataBytes, err := json.Marshal(v)
-		if err != nil {
-			return nil, err
-		}
-		return c.req(subj, dataBytes, timeout)
+// RequestContext sends request encoded with client config's codec and returns reply's message.
+// Client config's timeout is applied unless given context already has deadline.
+// Error reply is returned as *httputil.Err error.
+func (c *Conn) RequestContext(ctx context.Context, subj string, v any, opts ...ClientOption) (*nats.Msg, error) {
+	msg, err := NewRequestMsg(subj, v, c.ClientConfig(opts...).PayloadCodec())
+	if err != nil {
+		return nil, err
 	}
-	return c.req(subj, nil, timeout)
+	return c.RequestMsg(ctx, msg, opts...)
 }
 
-// RequestBytes sends request and returns reply's bytes
-func (c *Conn) RequestBytes(subj string, v any, timeout time.Duration) ([]byte, error) {
-	resp, err := c.Request(subj, v, timeout)
+// RequestBytesContext sends request and returns reply's bytes
+func (c *Conn) RequestBytesContext(ctx context.Context, subj string, v any, opts ...ClientOption) ([]byte, error) {
+	resp, err := c.RequestContext(ctx, subj, v, opts...)
 	if err != nil {
 		return nil, err
 	}
 	return resp.Data, nil
 }
 
-// RequestJSON sends requests and unmarshals reply's json bytes into given destination
-func (c *Conn) RequestJSON(subj string, v any, timeout time.Duration, destPtr any) error {
-	respBytes, err := c.RequestBytes(subj, v, timeout)
+// RequestJSONContext sends requests and decodes reply into given destination,
+// reply is decoded with codec of its content type, JSON if it's missing
+func (c *Conn) RequestJSONContext(ctx context.Context, subj string, v any, destPtr any, opts ...ClientOption) error {
+	resp, err := c.RequestContext(ctx, subj, v, opts...)
 	if err != nil {
 		return err
 	}
-	return json.Unmarshal(respBytes, destPtr)
+	return DecodeMsg(resp, destPtr)
 }
 
-// Close unsubscribes consumers and closes connections
+// timeoutOpts returns options overriding client config's timeout, zero timeout keeps the default
+func timeoutOpts(timeout time.Duration) []ClientOption {
+	if timeout == 0 {
+		return nil
+	}
+	return []ClientOption{WithTimeout(timeout)}
+}
+
+// Request sends request and returns reply's message.
+// Zero timeout falls back to client config's timeout.
+// Error reply is returned as *httputil.Err error.
+func (c *Conn) Request(subj string, v any, timeout time.Duration) (*nats.Msg, error) {
+	return c.RequestContext(context.Background(), subj, v, timeoutOpts(timeout)...)
+}
+
+// RequestBytes sends request and returns reply's bytes
+func (c *Conn) RequestBytes(subj string, v any, timeout time.Duration) ([]byte, error) {
+	return c.RequestBytesContext(context.Background(), subj, v, timeoutOpts(timeout)...)
+}
+
+// RequestJSON sends requests and unmarshals reply's json bytes into given destination
+func (c *Conn) RequestJSON(subj string, v any, timeout time.Duration, destPtr any) error {
+	return c.RequestJSONContext(context.Background(), subj, v, destPtr, timeoutOpts(timeout)...)
+}
+
+// Close unsubscribes consumers and closes connections, in-flight messages are dropped, see Drain
 func (c *Conn) Close() error {
+	c.cancel()
+	c.subsMu.Lock()
+	defer c.subsMu.Unlock()
+	for cc := range c.consumers {
+		cc.Stop()
+		delete(c.consumers, cc)
+	}
 	for sub := range c.subscriptions {
 		if err := sub.Unsubscribe(); err != nil {
 			return err
@@ -138,19 +284,45 @@ func (c *Conn) Close() error {
 
 // ConnConfig defines connection configuration
 type ConnConfig struct {
-	Env           envutil.AppEnv
-	URL           string
-	User          string
-	Password      string
+	Env envutil.AppEnv
+	URL string
+	// URLs defines additional cluster servers
+	URLs     []string
+	User     string
+	Password string
+	Token    string
+	// NKeySeedFile defines path to the nkey seed file
+	NKeySeedFile string
+	// CredsFile defines path to the user JWT credentials file
+	CredsFile     string
+	TLS           *TLSConfig
 	Timeout       time.Duration
 	ErrHandler    ErrHandlerFunc
 	ReconnectWait time.Duration
 	MaxReconnects int
+	NakDelay      time.Duration
+	// Namer defines subjects and stream names namespacing, defaults to DefaultNamer
+	Namer SubjectNamer
+	// Client defines default request options, its env defaults to connection's env
+	Client *ClientConfig
+	// Codec defines default payloads codec, it overrides client's one, defaults to JSONCodec
+	Codec Codec
+	// HandlerTimeout defines deadline of handler's context, defaults to client's timeout or 8s if client has none
+	HandlerTimeout time.Duration
+	// OnDisconnect is called when connection is lost
+	OnDisconnect func(err error)
+	// OnReconnect is called when connection is re-established
+	OnReconnect func()
+	// OnClosed is called when connection is closed and won't be reconnected anymore
+	OnClosed func()
+	// OnError is called on asynchronous errors, e.g. slow consumer
+	OnError func(err error)
 }
 
 // NewConn returns new connection value.
 // It fails if initial connection cannot be established.
-// If connection is lost after being established, it retries automatically.
+// If connection is lost after being established, it retries automatically,
+// once retries are exhausted connection is closed and config's OnClosed is called.
 func NewConn(config *ConnConfig) (*Conn, error) {
 	timeout := config.Timeout
 	if timeout == 0 {
@@ -164,59 +336,110 @@ func NewConn(config *ConnConfig) (*Conn, error) {
 	if maxReconnects == 0 {
 		maxReconnects = defaultMaxReconnect
 	}
-	conn, err := nats.Connect(
-		config.URL,
-		nats.UserInfo(config.User, config.Password),
+	closed := make(chan struct{})
+	opts, err := config.authOptions()
+	if err != nil {
+		return nil, err
+	}
+	conn, err := nats.Connect(config.servers(), append(opts,
 		nats.Timeout(timeout),
 		nats.ReconnectWait(reconnectWait),
 		nats.MaxReconnects(maxReconnects),
-		nats.DisconnectErrHandler(func(_ *nats.Conn, err error) {
-			log.Warn().Err(err).Msg("nats: connection lost, reconnecting")
+		nats.DisconnectErrHandler(func(nc *nats.Conn, err error) {
+			// disconnect handler is called on close too
+			if !nc.IsClosed() {
+				log.Warn().Err(err).Msg("nats: connection lost, reconnecting")
+			}
+			if config.OnDisconnect != nil {
+				config.OnDisconnect(err)
+			}
 		}),
 		nats.ReconnectHandler(func(_ *nats.Conn) {
 			log.Info().Msg("nats: reconnected")
+			if config.OnReconnect != nil {
+				config.OnReconnect()
+			}
 		}),
 		nats.ClosedHandler(func(_ *nats.Conn) {
-			log.Fatal().Msg("nats: connection closed, exiting")
+			log.Info().Msg("nats: connection closed")
+			close(closed)
+			if config.OnClosed != nil {
+				config.OnClosed()
+			}
+		}),
+		nats.ErrorHandler(func(_ *nats.Conn, sub *nats.Subscription, err error) {
+			e := log.Error().Err(err)
+			if sub != nil {
+				e = e.Str("subject", sub.Subject)
+			}
+			e.Msg("nats: async error")
+			if config.OnError != nil {
+				config.OnError(err)
+			}
 		}),
-	)
+	)...)
+	if err != nil {
+		return nil, err
+	}
+	js, err := jetstream.New(conn)
 	if err != nil {
+		conn.Close()
 		return nil, err
 	}
 	errHandler := config.ErrHandler
 	if errHandler == nil {
 		errHandler = DefaultErrHandler
 	}
+	nakDelay := config.NakDelay
+	if nakDelay == 0 {
+		nakDelay = defaultNakDelay
+	}
+	client := DefaultClientConfig()
+	client.Env = config.Env.String()
+	if config.Client != nil {
+		client = config.Client.Copy()
+		if client.Env == "" {
+			client.Env = config.Env.String()
+		}
+	}
+	if config.Codec != nil {
+		client = ClientConfigure(client, []ClientOption{WithCodec(config.Codec)})
+	}
+	handlerTimeout := config.HandlerTimeout
+	if handlerTimeout <= 0 {
+		handlerTimeout = client.Timeout
+	}
+	// client without timeout waits for replies as long as context allows, handlers still get deadline
+	if handlerTimeout <= 0 {
+		handlerTimeout = defaultTimeout
+	}
+	namer := config.Namer
+	if namer == nil {
+		namer = DefaultNamer
+	}
+	ctx, cancel := context.WithCancel(context.Background())
 	return &Conn{
-		env:           config.Env,
-		conn:          conn,
-		subscriptions: make(map[*nats.Subscription]struct{}),
-		ErrHandler:    errHandler,
+		env:            config.Env,
+		namer:          namer,
+		conn:           conn,
+		js:             js,
+		subscriptions:  make(map[*nats.Subscription]struct{}),
+		consumers:      make(map[jetstream.ConsumeContext]struct{}),
+		nakDelay:       nakDelay,
+		client:         client,
+		handlerTimeout: handlerTimeout,
+		ctx:            ctx,
+		cancel:         cancel,
+		closed:         closed,
+		ErrHandler:     errHandler,
 	}, nil
 }
 
-// DefaultConn returns new connection initialized from env variables
+// DefaultConn returns new connection initialized from env variables, see ConfigFromEnv
 func DefaultConn() (*Conn, error) {
-	env, err := envutil.NewAppEnv()
-	if err != nil {
-		return nil, err
-	}
-	url, err := envutil.ShouldStr("NATS_URL")
-	if err != nil {
-		return nil, err
-	}
-	user, err := envutil.ShouldStr("NATS_USER")
-	if err != nil {
-		return nil, err
-	}
-	password, err := envutil.ShouldStr("NATS_PASSWORD")
+	config, err := ConfigFromEnv()
 	if err != nil {
 		return nil, err
 	}
-	return NewConn(&ConnConfig{
-		Env:      env,
-		URL:      url,
-		User:     user,
-		Password: password,
-	})
+	return NewConn(config)
 }
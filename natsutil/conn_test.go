@@ -0,0 +1,113 @@
+package natsutil_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+
+	"github.com/avakarev/go-util/natsutil"
+	"github.com/avakarev/go-util/testutil"
+)
+
+// handlerDeadline publishes message to the handler and returns time left until its context's deadline
+func handlerDeadline(c *natsutil.Conn, t *testing.T) time.Duration {
+	t.Helper()
+	left := make(chan time.Duration, 1)
+	testutil.MustNoErr(c.SubscribeContext("deadline", func(ctx context.Context, _ *nats.Msg) error {
+		deadline, ok := ctx.Deadline()
+		if !ok || ctx.Err() != nil {
+			left <- 0
+			return nil
+		}
+		left <- time.Until(deadline)
+		return nil
+	}), t)
+	testutil.MustNoErr(c.Publish("deadline", nil), t)
+	select {
+	case d := <-left:
+		return d
+	case <-time.After(5 * time.Second):
+		t.Fatal("handler wasn't called")
+	}
+	return 0
+}
+
+func TestHandlerContextDeadline(t *testing.T) {
+	s := runServer(t)
+	cases := []struct {
+		name     string
+		config   func(config *natsutil.ConnConfig)
+		min, max time.Duration
+	}{
+		{
+			name:   "handler timeout",
+			config: func(config *natsutil.ConnConfig) { config.HandlerTimeout = 2 * time.Second },
+			min:    time.Second, max: 2 * time.Second,
+		},
+		{
+			name: "client timeout",
+			config: func(config *natsutil.ConnConfig) {
+				config.Client = &natsutil.ClientConfig{Timeout: 3 * time.Second}
+			},
+			min: 2 * time.Second, max: 3 * time.Second,
+		},
+		{
+			name:   "default client",
+			config: func(*natsutil.ConnConfig) {},
+			min:    7 * time.Second, max: 8 * time.Second,
+		},
+		{
+			name: "client without timeout",
+			config: func(config *natsutil.ConnConfig) {
+				config.Client = &natsutil.ClientConfig{}
+			},
+			min: 7 * time.Second, max: 8 * time.Second,
+		},
+	}
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			left := handlerDeadline(newConn(t, s, tt.config), t)
+			if left < tt.min || left > tt.max {
+				t.Errorf("handler's deadline is in %s, want within [%s, %s]", left, tt.min, tt.max)
+			}
+		})
+	}
+}
+
+func TestHandlerContextClose(t *testing.T) {
+	c := newConn(t, runServer(t))
+	started := make(chan struct{})
+	done := make(chan error, 1)
+	testutil.MustNoErr(c.SubscribeContext("orders.process", func(ctx context.Context, _ *nats.Msg) error {
+		close(started)
+		<-ctx.Done()
+		done <- ctx.Err()
+		return nil
+	}), t)
+	testutil.MustNoErr(c.Publish("orders.process", nil), t)
+	<-started
+
+	testutil.MustNoErr(c.Close(), t)
+	select {
+	case err := <-done:
+		testutil.Diff(context.Canceled, err, t)
+	case <-time.After(5 * time.Second):
+		t.Fatal("handler's context wasn't cancelled on close")
+	}
+}
+
+func TestClientEnvDefault(t *testing.T) {
+	s := runServer(t)
+	c := newConn(t, s, func(config *natsutil.ConnConfig) {
+		config.Client = &natsutil.ClientConfig{Timeout: time.Second}
+	})
+	testutil.MustNoErr(c.Subscribe("orders.get", func(msg *nats.Msg) error {
+		return natsutil.Respond(msg, []byte(msg.Subject))
+	}), t)
+
+	reply, err := c.RequestContext(context.Background(), "orders.get", nil)
+	testutil.MustNoErr(err, t)
+	testutil.Diff("dev.orders.get", string(reply.Data), t)
+}
@@ -0,0 +1,171 @@
+package natsutil
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+	"github.com/rs/zerolog/log"
+)
+
+// ErrTerm marks poison message errors, such messages are terminated instead of being redelivered
+var ErrTerm = errors.New("poison message")
+
+// Term wraps given error so that jetstream message is terminated instead of being redelivered
+func Term(err error) error {
+	return fmt.Errorf("%w: %w", ErrTerm, err)
+}
+
+// JetStream returns jetstream context of the connection
+func (c *Conn) JetStream() jetstream.JetStream {
+	return c.js
+}
+
+func (c *Conn) enrichSubjs(subjs []string) []string {
+	enriched := make([]string, len(subjs))
+	for i, subj := range subjs {
+		enriched[i] = c.enrichSubj(subj)
+	}
+	return enriched
+}
+
+// EnsureStream creates or updates stream with given config.
+// Stream name and subjects are prefixed with current env.
+func (c *Conn) EnsureStream(ctx context.Context, cfg jetstream.StreamConfig) (jetstream.Stream, error) {
+	cfg.Name = c.enrichName(cfg.Name)
+	cfg.Subjects = c.enrichSubjs(cfg.Subjects)
+	stream, err := c.js.CreateOrUpdateStream(ctx, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("%w, stream=%q", err, cfg.Name)
+	}
+	return stream, nil
+}
+
+func (c *Conn) consumerConfig(cfg jetstream.ConsumerConfig) jetstream.ConsumerConfig {
+	if cfg.FilterSubject != "" {
+		cfg.FilterSubject = c.enrichSubj(cfg.FilterSubject)
+	}
+	cfg.FilterSubjects = c.enrichSubjs(cfg.FilterSubjects)
+	if cfg.DeliverSubject != "" {
+		cfg.DeliverSubject = c.enrichSubj(cfg.DeliverSubject)
+	}
+	return cfg
+}
+
+// EnsureConsumer creates or updates pull consumer of the given stream.
+// Consumer is durable if config's Durable is set.
+func (c *Conn) EnsureConsumer(ctx context.Context, stream string, cfg jetstream.ConsumerConfig) (jetstream.Consumer, error) {
+	stream = c.enrichName(stream)
+	cons, err := c.js.CreateOrUpdateConsumer(ctx, stream, c.consumerConfig(cfg))
+	if err != nil {
+		return nil, fmt.Errorf("%w, stream=%q", err, stream)
+	}
+	return cons, nil
+}
+
+// EnsurePushConsumer creates or updates push consumer of the given stream.
+// Config's DeliverSubject is required.
+func (c *Conn) EnsurePushConsumer(ctx context.Context, stream string, cfg jetstream.ConsumerConfig) (jetstream.PushConsumer, error) {
+	stream = c.enrichName(stream)
+	cons, err := c.js.CreateOrUpdatePushConsumer(ctx, stream, c.consumerConfig(cfg))
+	if err != nil {
+		return nil, fmt.Errorf("%w, stream=%q", err, stream)
+	}
+	return cons, nil
+}
+
+// PublishJS sends byte slice to the given stream subject and waits for ack.
+// Non-empty msgID is used by the stream to deduplicate messages.
+func (c *Conn) PublishJS(ctx context.Context, subj string, data []byte, msgID string) (*jetstream.PubAck, error) {
+	subj = c.enrichSubj(subj)
+	opts := make([]jetstream.PublishOpt, 0)
+	if msgID != "" {
+		opts = append(opts, jetstream.WithMsgID(msgID))
+	}
+	ack, err := c.js.Publish(ctx, subj, data, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("%w, subj=%q", err, subj)
+	}
+	return ack, nil
+}
+
+// PublishJSONJS marshals given value into JSON and sends to the given stream subject
+func (c *Conn) PublishJSONJS(ctx context.Context, subj string, v any, msgID string) (*jetstream.PubAck, error) {
+	bytes, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	return c.PublishJS(ctx, subj, bytes, msgID)
+}
+
+// JSMsgHandler adapts given handler to jetstream message handler,
+// handler is wrapped with connection's and given middlewares, see Use.
+// Handler's context carries request id and trace context of the message, see ContextFromHeader.
+// Message is acked on success, terminated if error wraps ErrTerm and nacked with delay otherwise.
+func (c *Conn) JSMsgHandler(fn MsgContextHandlerFunc, mws ...Middleware) jetstream.MessageHandler {
+	handler := c.chain(fn, mws)
+	return func(m jetstream.Msg) {
+		// reply subject is omitted on purpose: it's an ack subject, not requester's inbox
+		msg := &nats.Msg{Subject: m.Subject(), Header: m.Headers(), Data: m.Data()}
+		ctx, cancel := c.handlerCtx()
+		defer cancel()
+		err := handler(ContextFromHeader(ctx, msg.Header), msg)
+		if err == nil {
+			if err := m.Ack(); err != nil {
+				log.Error().Err(err).Str("subject", msg.Subject).Msg("nats: ack failed")
+			}
+			return
+		}
+
+		c.ErrHandler(msg, err)
+		action := "nak"
+		if errors.Is(err, ErrTerm) {
+			action = "term"
+			err = m.TermWithReason(err.Error())
+		} else {
+			err = m.NakWithDelay(c.nakDelay)
+		}
+		if err != nil {
+			log.Error().Err(err).Str("subject", msg.Subject).Msg("nats: " + action + " failed")
+		}
+	}
+}
+
+// Consume runs given handler over messages of the existing pull consumer until connection is closed
+func (c *Conn) Consume(ctx context.Context, stream string, consumer string, fn MsgContextHandlerFunc, mws ...Middleware) error {
+	stream = c.enrichName(stream)
+	cons, err := c.js.Consumer(ctx, stream, consumer)
+	if err != nil {
+		return fmt.Errorf("%w, stream=%q, consumer=%q", err, stream, consumer)
+	}
+	cc, err := cons.Consume(c.JSMsgHandler(fn, mws...))
+	if err != nil {
+		return fmt.Errorf("%w, stream=%q, consumer=%q", err, stream, consumer)
+	}
+	log.Debug().Str("stream", stream).Str("consumer", consumer).Msg("nats: consuming")
+	c.subsMu.Lock()
+	c.consumers[cc] = struct{}{}
+	c.subsMu.Unlock()
+	return nil
+}
+
+// ConsumePush runs given handler over messages of the existing push consumer until connection is closed
+func (c *Conn) ConsumePush(ctx context.Context, stream string, consumer string, fn MsgContextHandlerFunc, mws ...Middleware) error {
+	stream = c.enrichName(stream)
+	cons, err := c.js.PushConsumer(ctx, stream, consumer)
+	if err != nil {
+		return fmt.Errorf("%w, stream=%q, consumer=%q", err, stream, consumer)
+	}
+	cc, err := cons.Consume(c.JSMsgHandler(fn, mws...))
+	if err != nil {
+		return fmt.Errorf("%w, stream=%q, consumer=%q", err, stream, consumer)
+	}
+	log.Debug().Str("stream", stream).Str("consumer", consumer).Msg("nats: consuming")
+	c.subsMu.Lock()
+	c.consumers[cc] = struct{}{}
+	c.subsMu.Unlock()
+	return nil
+}
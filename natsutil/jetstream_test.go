@@ -0,0 +1,270 @@
+package natsutil_test
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+
+	"github.com/avakarev/go-util/natsutil"
+	"github.com/avakarev/go-util/natsutil/natstest"
+	"github.com/avakarev/go-util/testutil"
+)
+
+type jsMsg struct {
+	jetstream.Msg
+	Header nats.Header
+	Result string
+}
+
+func (m *jsMsg) Subject() string                           { return "dev.orders.created" }
+func (m *jsMsg) Headers() nats.Header                      { return m.Header }
+func (m *jsMsg) Data() []byte                              { return []byte("{}") }
+func (m *jsMsg) Ack() error                                { m.Result = "ack"; return nil }
+func (m *jsMsg) NakWithDelay(time.Duration) error          { m.Result = "nak"; return nil }
+func (m *jsMsg) TermWithReason(reason string) error        { m.Result = "term: " + reason; return nil }
+func (m *jsMsg) DoubleAck(context.Context) error           { return nil }
+func (m *jsMsg) Metadata() (*jetstream.MsgMetadata, error) { return nil, nil }
+
+func TestJSMsgHandler(t *testing.T) {
+	errs := make([]string, 0)
+	c := &natsutil.Conn{ErrHandler: func(_ *nats.Msg, err error) {
+		errs = append(errs, err.Error())
+	}}
+	cases := []struct {
+		err    error
+		Result string
+	}{
+		{err: nil, Result: "ack"},
+		{err: errors.New("db is down"), Result: "nak"},
+		{err: natsutil.Term(errors.New("malformed")), Result: "term: poison message: malformed"},
+	}
+	for _, tt := range cases {
+		m := &jsMsg{}
+		c.JSMsgHandler(func(_ context.Context, msg *nats.Msg) error {
+			testutil.Diff("", msg.Reply, t)
+			return tt.err
+		})(m)
+		testutil.Diff(tt.Result, m.Result, t)
+	}
+	testutil.Diff([]string{"db is down", "poison message: malformed"}, errs, t)
+}
+
+func TestJSMsgHandlerContext(t *testing.T) {
+	c := &natsutil.Conn{}
+	var id string
+	c.JSMsgHandler(func(ctx context.Context, _ *nats.Msg) error {
+		id = natsutil.RequestID(ctx)
+		return nil
+	})(&jsMsg{Header: nats.Header{natsutil.RequestIDHeader: []string{"req-1"}}})
+	testutil.Diff("req-1", id, t)
+}
+
+func TestJSMsgHandlerDeadLetter(t *testing.T) {
+	bus := natstest.NewFakeBus("dev")
+	c := &natsutil.Conn{ErrHandler: func(*nats.Msg, error) {}}
+	calls := 0
+	m := &jsMsg{}
+	c.JSMsgHandler(func(_ context.Context, msg *nats.Msg) error {
+		calls++
+		return errors.New("db is down")
+	}, natsutil.DeadLetter(bus, "orders.dlq", nil), natsutil.Retry(natsutil.RetryPolicy{Attempts: 2}))(m)
+	testutil.Diff(2, calls, t)
+	testutil.Diff("term: db is down", m.Result, t)
+	testutil.Diff(1, len(bus.Published("orders.dlq")), t)
+}
+
+func TestEnsureStream(t *testing.T) {
+	c := newConn(t, runServer(t))
+	ctx := context.Background()
+
+	stream, err := c.EnsureStream(ctx, jetstream.StreamConfig{Name: "orders", Subjects: []string{"orders.>"}})
+	testutil.MustNoErr(err, t)
+	info := stream.CachedInfo()
+	testutil.Diff("dev_orders", info.Config.Name, t)
+	testutil.Diff([]string{"dev.orders.>"}, info.Config.Subjects, t)
+
+	stream, err = c.EnsureStream(ctx, jetstream.StreamConfig{Name: "orders", Subjects: []string{"orders.>"}, MaxMsgs: 10})
+	testutil.MustNoErr(err, t)
+	testutil.Diff(int64(10), stream.CachedInfo().Config.MaxMsgs, t)
+
+	_, err = c.EnsureStream(ctx, jetstream.StreamConfig{Name: "orders", Subjects: []string{"orders.>"}, Storage: jetstream.MemoryStorage})
+	testutil.Diff(true, err != nil, t)
+}
+
+func TestEnsureConsumer(t *testing.T) {
+	c := newConn(t, runServer(t))
+	ctx := context.Background()
+	_, err := c.EnsureStream(ctx, jetstream.StreamConfig{Name: "orders", Subjects: []string{"orders.>"}})
+	testutil.MustNoErr(err, t)
+
+	cons, err := c.EnsureConsumer(ctx, "orders", jetstream.ConsumerConfig{Durable: "worker", FilterSubject: "orders.created"})
+	testutil.MustNoErr(err, t)
+	info := cons.CachedInfo()
+	testutil.Diff("dev_orders", info.Stream, t)
+	testutil.Diff("worker", info.Name, t)
+	testutil.Diff("dev.orders.created", info.Config.FilterSubject, t)
+
+	cons, err = c.EnsureConsumer(ctx, "orders", jetstream.ConsumerConfig{Durable: "worker", FilterSubjects: []string{"orders.created", "orders.deleted"}})
+	testutil.MustNoErr(err, t)
+	testutil.Diff([]string{"dev.orders.created", "dev.orders.deleted"}, cons.CachedInfo().Config.FilterSubjects, t)
+
+	_, err = c.EnsureConsumer(ctx, "invoices", jetstream.ConsumerConfig{Durable: "worker"})
+	testutil.Diff(true, errors.Is(err, jetstream.ErrStreamNotFound), t)
+}
+
+func TestEnsurePushConsumer(t *testing.T) {
+	c := newConn(t, runServer(t))
+	ctx := context.Background()
+	_, err := c.EnsureStream(ctx, jetstream.StreamConfig{Name: "orders", Subjects: []string{"orders.>"}})
+	testutil.MustNoErr(err, t)
+
+	cons, err := c.EnsurePushConsumer(ctx, "orders", jetstream.ConsumerConfig{Durable: "mailer", DeliverSubject: "deliver.mailer"})
+	testutil.MustNoErr(err, t)
+	testutil.Diff("dev.deliver.mailer", cons.CachedInfo().Config.DeliverSubject, t)
+
+	_, err = c.EnsurePushConsumer(ctx, "orders", jetstream.ConsumerConfig{Durable: "nowhere"})
+	testutil.Diff(true, err != nil, t)
+}
+
+func TestPublishJS(t *testing.T) {
+	c := newConn(t, runServer(t))
+	ctx := context.Background()
+	stream, err := c.EnsureStream(ctx, jetstream.StreamConfig{Name: "orders", Subjects: []string{"orders.>"}})
+	testutil.MustNoErr(err, t)
+
+	ack, err := c.PublishJSONJS(ctx, "orders.created", order{ID: "1"}, "order-1")
+	testutil.MustNoErr(err, t)
+	testutil.Diff("dev_orders", ack.Stream, t)
+	testutil.Diff(false, ack.Duplicate, t)
+
+	ack, err = c.PublishJSONJS(ctx, "orders.created", order{ID: "1"}, "order-1")
+	testutil.MustNoErr(err, t)
+	testutil.Diff(true, ack.Duplicate, t)
+	testutil.Diff(uint64(1), ack.Sequence, t)
+
+	// messages without id aren't deduplicated
+	for range 2 {
+		_, err = c.PublishJS(ctx, "orders.created", []byte("{}"), "")
+		testutil.MustNoErr(err, t)
+	}
+	info, err := stream.Info(ctx)
+	testutil.MustNoErr(err, t)
+	testutil.Diff(uint64(3), info.State.Msgs, t)
+
+	_, err = c.PublishJS(ctx, "invoices.created", nil, "")
+	testutil.Diff(true, errors.Is(err, jetstream.ErrNoStreamResponse), t)
+}
+
+// consumed collects results of the jetstream handler
+type consumed struct {
+	mu    sync.Mutex
+	calls []string
+	done  chan struct{}
+}
+
+func (c *consumed) add(call string) {
+	c.mu.Lock()
+	c.calls = append(c.calls, call)
+	c.mu.Unlock()
+	c.done <- struct{}{}
+}
+
+func (c *consumed) wait(n int, t *testing.T) []string {
+	t.Helper()
+	for range n {
+		select {
+		case <-c.done:
+		case <-time.After(5 * time.Second):
+			t.Fatal("jetstream message wasn't consumed")
+		}
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return slices.Clone(c.calls)
+}
+
+func TestConsume(t *testing.T) {
+	c := newConn(t, runServer(t))
+	ctx := context.Background()
+	stream, err := c.EnsureStream(ctx, jetstream.StreamConfig{Name: "orders", Subjects: []string{"orders.>"}})
+	testutil.MustNoErr(err, t)
+	_, err = c.EnsureConsumer(ctx, "orders", jetstream.ConsumerConfig{Durable: "worker", AckPolicy: jetstream.AckExplicitPolicy})
+	testutil.MustNoErr(err, t)
+
+	res := &consumed{done: make(chan struct{}, 10)}
+	var seen atomic.Int32
+	c.Use(func(next natsutil.MsgContextHandlerFunc) natsutil.MsgContextHandlerFunc {
+		return func(ctx context.Context, msg *nats.Msg) error {
+			seen.Add(1)
+			return next(ctx, msg)
+		}
+	})
+	failed := false
+	testutil.MustNoErr(c.Consume(ctx, "orders", "worker", func(_ context.Context, msg *nats.Msg) error {
+		defer res.add(msg.Subject)
+		switch {
+		case msg.Subject == "dev.orders.poison":
+			return natsutil.Term(errors.New("malformed"))
+		case !failed:
+			failed = true
+			return errors.New("db is down")
+		}
+		return nil
+	}), t)
+
+	_, err = c.PublishJS(ctx, "orders.created", []byte("{}"), "")
+	testutil.MustNoErr(err, t)
+	testutil.Diff([]string{"dev.orders.created", "dev.orders.created"}, res.wait(2, t), t)
+	_, err = c.PublishJS(ctx, "orders.poison", []byte("{}"), "")
+	testutil.MustNoErr(err, t)
+	testutil.Diff("dev.orders.poison", res.wait(1, t)[2], t)
+
+	// acked and terminated messages are not redelivered
+	time.Sleep(200 * time.Millisecond)
+	testutil.Diff(0, len(res.done), t)
+	testutil.Diff(int32(3), seen.Load(), t) // connection's middlewares wrap consumers too
+	info, err := stream.Info(ctx)
+	testutil.MustNoErr(err, t)
+	testutil.Diff(uint64(2), info.State.Msgs, t)
+
+	err = c.Consume(ctx, "orders", "missing", func(context.Context, *nats.Msg) error { return nil })
+	testutil.Diff(true, errors.Is(err, jetstream.ErrConsumerNotFound), t)
+}
+
+func TestConsumePush(t *testing.T) {
+	c := newConn(t, runServer(t))
+	ctx := context.Background()
+	_, err := c.EnsureStream(ctx, jetstream.StreamConfig{Name: "orders", Subjects: []string{"orders.>"}})
+	testutil.MustNoErr(err, t)
+	_, err = c.EnsurePushConsumer(ctx, "orders", jetstream.ConsumerConfig{
+		Durable:        "mailer",
+		DeliverSubject: "deliver.mailer",
+		AckPolicy:      jetstream.AckExplicitPolicy,
+	})
+	testutil.MustNoErr(err, t)
+
+	res := &consumed{done: make(chan struct{}, 10)}
+	failed := false
+	testutil.MustNoErr(c.ConsumePush(ctx, "orders", "mailer", func(_ context.Context, msg *nats.Msg) error {
+		defer res.add(string(msg.Data))
+		if !failed {
+			failed = true
+			return errors.New("smtp is down")
+		}
+		return nil
+	}), t)
+
+	_, err = c.PublishJS(ctx, "orders.created", []byte("1"), "")
+	testutil.MustNoErr(err, t)
+	testutil.Diff([]string{"1", "1"}, res.wait(2, t), t)
+
+	err = c.ConsumePush(ctx, "orders", "missing", func(context.Context, *nats.Msg) error { return nil })
+	testutil.Diff(true, errors.Is(err, jetstream.ErrConsumerNotFound), t)
+}
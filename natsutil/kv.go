@@ -0,0 +1,201 @@
+package natsutil
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/nats-io/nats.go/jetstream"
+	"github.com/rs/zerolog/log"
+)
+
+// Entry defines typed key-value entry
+type Entry[T any] struct {
+	Key      string
+	Value    T
+	Revision uint64
+	Created  time.Time
+	Op       jetstream.KeyValueOp
+}
+
+// Deleted checks whether entry is delete or purge marker
+func (e *Entry[T]) Deleted() bool {
+	return e.Op != jetstream.KeyValuePut
+}
+
+// KV implements typed JetStream key-value bucket, values are encoded as JSON.
+// Missing keys are reported with jetstream.ErrKeyNotFound, failed revision checks with jetstream.ErrKeyExists.
+type KV[T any] struct {
+	kv jetstream.KeyValue
+}
+
+// NewKV creates or updates key-value bucket with given config and returns typed wrapper of it.
+// Bucket name is prefixed with current env, e.g. "dev_settings".
+func NewKV[T any](ctx context.Context, conn *Conn, cfg jetstream.KeyValueConfig) (*KV[T], error) {
+	cfg.Bucket = conn.enrichName(cfg.Bucket)
+	kv, err := conn.js.CreateOrUpdateKeyValue(ctx, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("%w, bucket=%q", err, cfg.Bucket)
+	}
+	return WrapKV[T](kv), nil
+}
+
+// WrapKV returns typed wrapper of the given key-value bucket
+func WrapKV[T any](kv jetstream.KeyValue) *KV[T] {
+	return &KV[T]{kv: kv}
+}
+
+// Bucket returns underlying key-value bucket
+func (b *KV[T]) Bucket() jetstream.KeyValue {
+	return b.kv
+}
+
+func decodeEntry[T any](e jetstream.KeyValueEntry) (*Entry[T], error) {
+	entry := &Entry[T]{
+		Key:      e.Key(),
+		Revision: e.Revision(),
+		Created:  e.Created(),
+		Op:       e.Operation(),
+	}
+	if entry.Deleted() {
+		return entry, nil
+	}
+	if err := json.Unmarshal(e.Value(), &entry.Value); err != nil {
+		return nil, fmt.Errorf("%w, key=%q", err, entry.Key)
+	}
+	return entry, nil
+}
+
+// Get returns latest entry of the given key
+func (b *KV[T]) Get(ctx context.Context, key string) (*Entry[T], error) {
+	e, err := b.kv.Get(ctx, key)
+	if err != nil {
+		return nil, fmt.Errorf("%w, key=%q", err, key)
+	}
+	return decodeEntry[T](e)
+}
+
+// Put sets value of the given key and returns new revision
+func (b *KV[T]) Put(ctx context.Context, key string, v T) (uint64, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return 0, err
+	}
+	rev, err := b.kv.Put(ctx, key, data)
+	if err != nil {
+		return 0, fmt.Errorf("%w, key=%q", err, key)
+	}
+	return rev, nil
+}
+
+// Create sets value of the given key only if it doesn't exist yet and returns new revision
+func (b *KV[T]) Create(ctx context.Context, key string, v T) (uint64, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return 0, err
+	}
+	rev, err := b.kv.Create(ctx, key, data)
+	if err != nil {
+		return 0, fmt.Errorf("%w, key=%q", err, key)
+	}
+	return rev, nil
+}
+
+// Update sets value of the given key only if its latest revision equals given one and returns new revision
+func (b *KV[T]) Update(ctx context.Context, key string, v T, revision uint64) (uint64, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return 0, err
+	}
+	rev, err := b.kv.Update(ctx, key, data, revision)
+	if err != nil {
+		return 0, fmt.Errorf("%w, key=%q", err, key)
+	}
+	return rev, nil
+}
+
+// Delete places delete marker of the given key, history is preserved
+func (b *KV[T]) Delete(ctx context.Context, key string, opts ...jetstream.KVDeleteOpt) error {
+	if err := b.kv.Delete(ctx, key, opts...); err != nil {
+		return fmt.Errorf("%w, key=%q", err, key)
+	}
+	return nil
+}
+
+// Purge removes all revisions of the given key leaving only delete marker
+func (b *KV[T]) Purge(ctx context.Context, key string, opts ...jetstream.KVDeleteOpt) error {
+	if err := b.kv.Purge(ctx, key, opts...); err != nil {
+		return fmt.Errorf("%w, key=%q", err, key)
+	}
+	return nil
+}
+
+// History returns all known revisions of the given key, oldest first
+func (b *KV[T]) History(ctx context.Context, key string) ([]Entry[T], error) {
+	list, err := b.kv.History(ctx, key)
+	if err != nil {
+		return nil, fmt.Errorf("%w, key=%q", err, key)
+	}
+	entries := make([]Entry[T], 0, len(list))
+	for _, e := range list {
+		entry, err := decodeEntry[T](e)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, *entry)
+	}
+	return entries, nil
+}
+
+// Keys returns all keys of the bucket
+func (b *KV[T]) Keys(ctx context.Context) ([]string, error) {
+	keys, err := b.kv.Keys(ctx)
+	if errors.Is(err, jetstream.ErrNoKeysFound) {
+		return []string{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+// Watch streams latest values and subsequent updates of keys matching given pattern, e.g. "orders.*".
+// Channel is closed once given context is done. Undecodable values are logged and skipped.
+func (b *KV[T]) Watch(ctx context.Context, pattern string, opts ...jetstream.WatchOpt) (<-chan Entry[T], error) {
+	w, err := b.kv.Watch(ctx, pattern, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("%w, pattern=%q", err, pattern)
+	}
+	ch := make(chan Entry[T])
+	go func() {
+		defer close(ch)
+		defer func() { _ = w.Stop() }()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case e, ok := <-w.Updates():
+				if !ok {
+					return
+				}
+				// nil entry marks that all initial values are delivered
+				if e == nil {
+					continue
+				}
+				entry, err := decodeEntry[T](e)
+				if err != nil {
+					log.Error().Err(err).Str("bucket", e.Bucket()).Msg("nats: kv watch")
+					continue
+				}
+				select {
+				case ch <- *entry:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+	return ch, nil
+}
@@ -0,0 +1,175 @@
+package natsutil_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go/jetstream"
+
+	"github.com/avakarev/go-util/natsutil"
+	"github.com/avakarev/go-util/testutil"
+)
+
+type kvEntry struct {
+	jetstream.KeyValueEntry
+	key     string
+	value   string
+	rev     uint64
+	op      jetstream.KeyValueOp
+	created time.Time
+}
+
+func (e *kvEntry) Key() string                     { return e.key }
+func (e *kvEntry) Value() []byte                   { return []byte(e.value) }
+func (e *kvEntry) Revision() uint64                { return e.rev }
+func (e *kvEntry) Created() time.Time              { return e.created }
+func (e *kvEntry) Operation() jetstream.KeyValueOp { return e.op }
+
+type kvBucket struct {
+	jetstream.KeyValue
+	history []jetstream.KeyValueEntry
+}
+
+func (b *kvBucket) Get(_ context.Context, key string) (jetstream.KeyValueEntry, error) {
+	if len(b.history) == 0 {
+		return nil, jetstream.ErrKeyNotFound
+	}
+	return b.history[len(b.history)-1], nil
+}
+
+func (b *kvBucket) History(context.Context, string, ...jetstream.WatchOpt) ([]jetstream.KeyValueEntry, error) {
+	return b.history, nil
+}
+
+func (b *kvBucket) Keys(context.Context, ...jetstream.WatchOpt) ([]string, error) {
+	return nil, jetstream.ErrNoKeysFound
+}
+
+type setting struct {
+	Enabled bool `json:"enabled"`
+}
+
+func TestKV(t *testing.T) {
+	bucket := &kvBucket{}
+	kv := natsutil.WrapKV[setting](bucket)
+
+	_, err := kv.Get(context.Background(), "flags.beta")
+	testutil.Diff(true, errors.Is(err, jetstream.ErrKeyNotFound), t)
+
+	bucket.history = []jetstream.KeyValueEntry{
+		&kvEntry{key: "flags.beta", value: `{"enabled":true}`, rev: 1, op: jetstream.KeyValuePut},
+		&kvEntry{key: "flags.beta", rev: 2, op: jetstream.KeyValueDelete},
+	}
+	history, err := kv.History(context.Background(), "flags.beta")
+	testutil.MustNoErr(err, t)
+	testutil.Diff([]natsutil.Entry[setting]{
+		{Key: "flags.beta", Value: setting{Enabled: true}, Revision: 1, Op: jetstream.KeyValuePut},
+		{Key: "flags.beta", Revision: 2, Op: jetstream.KeyValueDelete},
+	}, history, t)
+
+	latest, err := kv.Get(context.Background(), "flags.beta")
+	testutil.MustNoErr(err, t)
+	testutil.Diff(true, latest.Deleted(), t)
+
+	bucket.history = append(bucket.history, &kvEntry{key: "flags.beta", value: "{", rev: 3, op: jetstream.KeyValuePut})
+	_, err = kv.Get(context.Background(), "flags.beta")
+	testutil.MustErr(errors.New(`unexpected end of JSON input, key="flags.beta"`), err, t)
+
+	keys, err := kv.Keys(context.Background())
+	testutil.MustNoErr(err, t)
+	testutil.Diff([]string{}, keys, t)
+}
+
+func TestKVRevisions(t *testing.T) {
+	c := newConn(t, runServer(t))
+	ctx := context.Background()
+	kv, err := natsutil.NewKV[setting](ctx, c, jetstream.KeyValueConfig{Bucket: "settings", History: 5})
+	testutil.MustNoErr(err, t)
+	testutil.Diff("dev_settings", kv.Bucket().Bucket(), t)
+
+	rev, err := kv.Create(ctx, "flags.beta", setting{Enabled: true})
+	testutil.MustNoErr(err, t)
+	testutil.Diff(uint64(1), rev, t)
+	_, err = kv.Create(ctx, "flags.beta", setting{})
+	testutil.Diff(true, errors.Is(err, jetstream.ErrKeyExists), t)
+
+	rev, err = kv.Update(ctx, "flags.beta", setting{}, rev)
+	testutil.MustNoErr(err, t)
+	testutil.Diff(uint64(2), rev, t)
+	// stale revision is rejected
+	_, err = kv.Update(ctx, "flags.beta", setting{Enabled: true}, 1)
+	testutil.Diff(true, errors.Is(err, jetstream.ErrKeyExists), t)
+	_, err = kv.Update(ctx, "flags.alpha", setting{Enabled: true}, 1)
+	testutil.Diff(true, errors.Is(err, jetstream.ErrKeyExists), t)
+
+	e, err := kv.Get(ctx, "flags.beta")
+	testutil.MustNoErr(err, t)
+	testutil.Diff(setting{}, e.Value, t)
+	testutil.Diff(uint64(2), e.Revision, t)
+
+	// deleted key may be created again
+	testutil.MustNoErr(kv.Delete(ctx, "flags.beta", jetstream.LastRevision(2)), t)
+	rev, err = kv.Create(ctx, "flags.beta", setting{Enabled: true})
+	testutil.MustNoErr(err, t)
+	testutil.Diff(uint64(4), rev, t)
+
+	history, err := kv.History(ctx, "flags.beta")
+	testutil.MustNoErr(err, t)
+	ops := make([]jetstream.KeyValueOp, 0, len(history))
+	for _, e := range history {
+		ops = append(ops, e.Op)
+	}
+	testutil.Diff([]jetstream.KeyValueOp{jetstream.KeyValuePut, jetstream.KeyValuePut, jetstream.KeyValueDelete, jetstream.KeyValuePut}, ops, t)
+}
+
+func TestKVWatch(t *testing.T) {
+	c := newConn(t, runServer(t))
+	ctx := context.Background()
+	kv, err := natsutil.NewKV[setting](ctx, c, jetstream.KeyValueConfig{Bucket: "settings"})
+	testutil.MustNoErr(err, t)
+	_, err = kv.Put(ctx, "flags.beta", setting{Enabled: true})
+	testutil.MustNoErr(err, t)
+
+	watchCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	updates, err := kv.Watch(watchCtx, "flags.*")
+	testutil.MustNoErr(err, t)
+	next := func() natsutil.Entry[setting] {
+		t.Helper()
+		select {
+		case e := <-updates:
+			e.Created = time.Time{}
+			return e
+		case <-time.After(5 * time.Second):
+			t.Fatal("watch update wasn't received")
+		}
+		return natsutil.Entry[setting]{}
+	}
+
+	// latest values are delivered first
+	testutil.Diff(natsutil.Entry[setting]{Key: "flags.beta", Value: setting{Enabled: true}, Revision: 1, Op: jetstream.KeyValuePut}, next(), t)
+
+	_, err = kv.Put(ctx, "limits.rate", setting{Enabled: true})
+	testutil.MustNoErr(err, t)
+	_, err = kv.Bucket().Put(ctx, "flags.malformed", []byte("{"))
+	testutil.MustNoErr(err, t)
+	_, err = kv.Put(ctx, "flags.alpha", setting{})
+	testutil.MustNoErr(err, t)
+	testutil.MustNoErr(kv.Delete(ctx, "flags.beta"), t)
+
+	// non-matching keys and undecodable values are skipped
+	testutil.Diff(natsutil.Entry[setting]{Key: "flags.alpha", Revision: 4, Op: jetstream.KeyValuePut}, next(), t)
+	e := next()
+	testutil.Diff(natsutil.Entry[setting]{Key: "flags.beta", Revision: 5, Op: jetstream.KeyValueDelete}, e, t)
+	testutil.Diff(true, e.Deleted(), t)
+
+	cancel()
+	select {
+	case _, ok := <-updates:
+		testutil.Diff(false, ok, t)
+	case <-time.After(5 * time.Second):
+		t.Fatal("watch channel wasn't closed")
+	}
+}
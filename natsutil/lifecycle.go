@@ -0,0 +1,95 @@
+package natsutil
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"slices"
+
+	"github.com/nats-io/nats.go"
+)
+
+// Drain stops receiving new messages, lets in-flight handlers finish and closes the connection.
+// If given context is done first, connection is closed immediately and context's error is returned.
+func (c *Conn) Drain(ctx context.Context) error {
+	defer c.cancel()
+	c.subsMu.Lock()
+	consumers := slices.Collect(maps.Keys(c.consumers))
+	c.subsMu.Unlock()
+	for _, cc := range consumers {
+		cc.Drain()
+	}
+	for _, cc := range consumers {
+		select {
+		case <-cc.Closed():
+		case <-ctx.Done():
+			c.conn.Close()
+			return ctx.Err()
+		}
+		c.subsMu.Lock()
+		delete(c.consumers, cc)
+		c.subsMu.Unlock()
+	}
+	if err := c.conn.Drain(); err != nil && !c.conn.IsClosed() {
+		return err
+	}
+	select {
+	case <-c.closed:
+	case <-ctx.Done():
+		c.conn.Close()
+		return ctx.Err()
+	}
+	c.subsMu.Lock()
+	clear(c.subscriptions)
+	c.subsMu.Unlock()
+	return nil
+}
+
+// Closed returns channel which is closed once connection is closed
+func (c *Conn) Closed() <-chan struct{} {
+	return c.closed
+}
+
+// State returns connection status, e.g. CONNECTED or RECONNECTING
+func (c *Conn) State() nats.Status {
+	return c.conn.Status()
+}
+
+// Health defines connection health report
+type Health struct {
+	Status        string `json:"status"`
+	Connected     bool   `json:"connected"`
+	URL           string `json:"url,omitempty"`
+	ServerID      string `json:"serverId,omitempty"`
+	Reconnects    uint64 `json:"reconnects"`
+	Subscriptions int    `json:"subscriptions"`
+	Consumers     int    `json:"consumers"`
+	LastError     string `json:"lastError,omitempty"`
+}
+
+// Health returns connection health report
+func (c *Conn) Health() Health {
+	c.subsMu.Lock()
+	defer c.subsMu.Unlock()
+	h := Health{
+		Status:        c.conn.Status().String(),
+		Connected:     c.conn.IsConnected(),
+		URL:           c.conn.ConnectedUrlRedacted(),
+		ServerID:      c.conn.ConnectedServerId(),
+		Reconnects:    c.conn.Stats().Reconnects,
+		Subscriptions: len(c.subscriptions),
+		Consumers:     len(c.consumers),
+	}
+	if err := c.conn.LastError(); err != nil {
+		h.LastError = err.Error()
+	}
+	return h
+}
+
+// Ready returns error unless connection is established, e.g. for readiness probes
+func (c *Conn) Ready() error {
+	if status := c.conn.Status(); status != nats.CONNECTED {
+		return fmt.Errorf("nats: connection is %s", status)
+	}
+	return nil
+}
@@ -0,0 +1,179 @@
+package natsutil_test
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+
+	"github.com/avakarev/go-util/natsutil"
+	"github.com/avakarev/go-util/testutil"
+)
+
+func TestDrain(t *testing.T) {
+	s := runServer(t)
+	c := newConn(t, s)
+	client := newConn(t, s)
+	ctx := context.Background()
+	_, err := c.EnsureStream(ctx, jetstream.StreamConfig{Name: "orders", Subjects: []string{"orders.>"}})
+	testutil.MustNoErr(err, t)
+	_, err = c.EnsureConsumer(ctx, "orders", jetstream.ConsumerConfig{Durable: "worker", AckPolicy: jetstream.AckExplicitPolicy})
+	testutil.MustNoErr(err, t)
+
+	started := make(chan struct{}, 2)
+	var finished atomic.Int32
+	slow := func(ctx context.Context) error {
+		started <- struct{}{}
+		select {
+		case <-time.After(200 * time.Millisecond):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		finished.Add(1)
+		return nil
+	}
+	testutil.MustNoErr(c.SubscribeContext("slow", func(ctx context.Context, msg *nats.Msg) error {
+		if err := slow(ctx); err != nil {
+			return err
+		}
+		return natsutil.Respond(msg, []byte("done"))
+	}), t)
+	testutil.MustNoErr(c.Consume(ctx, "orders", "worker", func(_ context.Context, msg *nats.Msg) error {
+		return slow(context.Background())
+	}), t)
+	testutil.Diff(natsutil.Health{
+		Status:        "CONNECTED",
+		Connected:     true,
+		URL:           s.ClientURL(),
+		ServerID:      s.ID(),
+		Subscriptions: 1,
+		Consumers:     1,
+	}, c.Health(), t)
+	testutil.MustNoErr(c.Ready(), t)
+	waitInterest(t, s, true, "dev.slow")
+
+	reply := make(chan error, 1)
+	go func() {
+		_, err := client.RequestContext(ctx, "slow", nil)
+		reply <- err
+	}()
+	_, err = client.PublishJS(ctx, "orders.created", nil, "")
+	testutil.MustNoErr(err, t)
+	<-started
+	<-started
+
+	drained := make(chan error, 1)
+	go func() { drained <- c.Drain(ctx) }()
+	select {
+	case <-c.Closed():
+		// handlers already running when draining started complete before connection is closed
+		testutil.Diff(int32(2), finished.Load(), t)
+	case <-time.After(5 * time.Second):
+		t.Fatal("connection wasn't closed")
+	}
+	testutil.MustNoErr(<-drained, t)
+	testutil.MustNoErr(<-reply, t)
+
+	h := c.Health()
+	testutil.Diff("CLOSED", h.Status, t)
+	testutil.Diff(0, h.Subscriptions, t)
+	testutil.Diff(0, h.Consumers, t)
+	testutil.MustErr(errors.New("nats: connection is CLOSED"), c.Ready(), t)
+}
+
+func TestDrainTimeout(t *testing.T) {
+	c := newConn(t, runServer(t))
+	started := make(chan struct{})
+	testutil.MustNoErr(c.Subscribe("stuck", func(*nats.Msg) error {
+		close(started)
+		time.Sleep(time.Second)
+		return nil
+	}), t)
+	testutil.MustNoErr(c.Publish("stuck", nil), t)
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	testutil.Diff(context.DeadlineExceeded, c.Drain(ctx), t)
+	select {
+	case <-c.Closed():
+	case <-time.After(5 * time.Second):
+		t.Fatal("connection wasn't closed once drain timed out")
+	}
+}
+
+func TestConnCallbacks(t *testing.T) {
+	auth := func(opts *server.Options) {
+		opts.Users = []*server.User{{
+			Username: "app",
+			Password: "secret",
+			Permissions: &server.Permissions{
+				Publish: &server.SubjectPermission{Deny: []string{"dev.forbidden"}},
+			},
+		}}
+	}
+	s := runServer(t, auth)
+
+	events := make(chan string, 10)
+	c := newConn(t, s, func(config *natsutil.ConnConfig) {
+		config.User = "app"
+		config.Password = "secret"
+		config.ReconnectWait = 20 * time.Millisecond
+		config.OnDisconnect = func(error) { events <- "disconnect" }
+		config.OnReconnect = func() { events <- "reconnect" }
+		config.OnClosed = func() { events <- "closed" }
+		config.OnError = func(err error) {
+			if strings.Contains(err.Error(), "Permissions Violation") {
+				events <- "error"
+			}
+		}
+	})
+	next := func(want string) {
+		t.Helper()
+		select {
+		case got := <-events:
+			testutil.Diff(want, got, t)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%s callback wasn't called", want)
+		}
+	}
+
+	testutil.MustNoErr(c.Publish("forbidden", nil), t)
+	next("error")
+
+	port := s.Addr().(*net.TCPAddr).Port
+	s.Shutdown()
+	next("disconnect")
+	testutil.Diff(true, c.Ready() != nil, t)
+	runServer(t, auth, func(opts *server.Options) { opts.Port = port })
+	next("reconnect")
+	testutil.MustNoErr(c.Ready(), t)
+	testutil.Diff(uint64(1), c.Health().Reconnects, t)
+
+	testutil.MustNoErr(c.Close(), t)
+	next("disconnect")
+	next("closed")
+}
+
+func TestHealthConcurrentSubscribe(t *testing.T) {
+	c := newConn(t, runServer(t))
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for range 20 {
+			_ = c.Health()
+		}
+	}()
+	for range 20 {
+		testutil.MustNoErr(c.Subscribe("orders.>", func(*nats.Msg) error { return nil }), t)
+	}
+	<-done
+	testutil.Diff(20, c.Health().Subscriptions, t)
+}
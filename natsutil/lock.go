@@ -0,0 +1,297 @@
+package natsutil
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/nats-io/nats.go/jetstream"
+	"github.com/rs/zerolog/log"
+
+	"github.com/avakarev/go-util/timeutil"
+)
+
+// LocksBucket defines name of the key-value bucket backing connection's locks
+const LocksBucket = "locks"
+
+var (
+	// ErrLocked is returned when lock is held by another owner
+	ErrLocked = errors.New("lock is held by another owner")
+	// ErrLockLost is returned when lock expired and was taken over or released by someone else
+	ErrLockLost = errors.New("lock is lost")
+)
+
+// lockState defines value of the lock key, lease expires at deadline set by owner's locker clock.
+// Leases are checked against clocks of the clients rather than expired by the server with per-key TTL,
+// since key-value update can't renew the TTL; server's timestamps aren't used at all.
+// Clients' clocks are expected to be synchronized, e.g. by NTP,
+// with skew well below the margin leaders step down with, see Election.
+type lockState struct {
+	Owner   string    `json:"owner"`
+	Expires time.Time `json:"expires"`
+}
+
+// Locker implements distributed locks on top of key-value bucket revisions
+type Locker struct {
+	kv    *KV[lockState]
+	clock timeutil.Clock
+	owner string
+}
+
+// NewLocker returns locker backed by given key-value bucket, nil clock means wall clock
+func NewLocker(bucket jetstream.KeyValue, clock timeutil.Clock) *Locker {
+	if clock == nil {
+		clock = timeutil.NewClock()
+	}
+	host, _ := os.Hostname()
+	return &Locker{kv: WrapKV[lockState](bucket), clock: clock, owner: host + "-" + uuid.NewString()}
+}
+
+// Locker returns connection's locker backed by LocksBucket, bucket is created on first use
+func (c *Conn) Locker(ctx context.Context) (*Locker, error) {
+	c.lockerMu.Lock()
+	defer c.lockerMu.Unlock()
+	if c.locker != nil {
+		return c.locker, nil
+	}
+	kv, err := NewKV[lockState](ctx, c, jetstream.KeyValueConfig{Bucket: LocksBucket})
+	if err != nil {
+		return nil, err
+	}
+	c.locker = NewLocker(kv.Bucket(), nil)
+	return c.locker, nil
+}
+
+// Lock acquires lock with given name for given ttl using connection's locker
+func (c *Conn) Lock(ctx context.Context, name string, ttl time.Duration) (*Lock, error) {
+	l, err := c.Locker(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return l.Lock(ctx, name, ttl)
+}
+
+func (l *Locker) expired(e *Entry[lockState]) bool {
+	return e.Deleted() || !l.clock.Now().Before(e.Value.Expires)
+}
+
+// Lock acquires lock with given name for given ttl.
+// It fails with ErrLocked if lock is held by another owner and its lease hasn't expired yet.
+func (l *Locker) Lock(ctx context.Context, name string, ttl time.Duration) (*Lock, error) {
+	// lease is counted from the request, so that owner never outlives the deadline seen by others
+	expires := l.clock.Now().Add(ttl)
+	state := lockState{Owner: l.owner, Expires: expires}
+	rev, err := l.kv.Create(ctx, name, state)
+	if errors.Is(err, jetstream.ErrKeyExists) {
+		var e *Entry[lockState]
+		if e, err = l.kv.Get(ctx, name); err != nil {
+			return nil, err
+		}
+		if !l.expired(e) {
+			return nil, fmt.Errorf("%w, name=%q, owner=%q", ErrLocked, name, e.Value.Owner)
+		}
+		rev, err = l.kv.Update(ctx, name, state, e.Revision)
+		if errors.Is(err, jetstream.ErrKeyExists) {
+			return nil, fmt.Errorf("%w, name=%q", ErrLocked, name)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &Lock{locker: l, name: name, ttl: ttl, token: rev, revision: rev, expires: expires}, nil
+}
+
+// Lock implements acquired distributed lock
+type Lock struct {
+	mu       sync.Mutex
+	locker   *Locker
+	name     string
+	ttl      time.Duration
+	token    uint64
+	revision uint64
+	expires  time.Time
+}
+
+// Token returns fencing token: it's increasing with every acquisition of the lock and stays the same on refresh
+func (l *Lock) Token() uint64 {
+	return l.token
+}
+
+// Expires returns time when lock's lease expires unless it's refreshed
+func (l *Lock) Expires() time.Time {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.expires
+}
+
+// Refresh extends lock's lease by its ttl, it fails with ErrLockLost if lock was taken over meanwhile
+func (l *Lock) Refresh(ctx context.Context) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	expires := l.locker.clock.Now().Add(l.ttl)
+	rev, err := l.locker.kv.Update(ctx, l.name, lockState{Owner: l.locker.owner, Expires: expires}, l.revision)
+	if errors.Is(err, jetstream.ErrKeyExists) {
+		return fmt.Errorf("%w, name=%q", ErrLockLost, l.name)
+	}
+	if err != nil {
+		return err
+	}
+	l.revision = rev
+	l.expires = expires
+	return nil
+}
+
+// Unlock releases the lock, it fails with ErrLockLost if lock was taken over meanwhile
+func (l *Lock) Unlock(ctx context.Context) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	err := l.locker.kv.Delete(ctx, l.name, jetstream.LastRevision(l.revision))
+	if errors.Is(err, jetstream.ErrKeyExists) {
+		return fmt.Errorf("%w, name=%q", ErrLockLost, l.name)
+	}
+	return err
+}
+
+// ElectionConfig defines leader election settings
+type ElectionConfig struct {
+	// Name defines name of the lock candidates compete for
+	Name string
+	// TTL defines leader's lease, it's renewed every third of it, defaults to 15s.
+	// Leader steps down once less than third of the lease is left, e.g. when renewals fail.
+	TTL time.Duration
+	// OnElected is called when candidate becomes leader with lock's fencing token
+	OnElected func(token uint64)
+	// OnLost is called when leader loses leadership, including resignation on shutdown
+	OnLost func()
+}
+
+// Election implements leader election, candidate competes for the lock and renews its lease while being leader
+type Election struct {
+	locker *Locker
+	config ElectionConfig
+	lock   *Lock
+	leader atomic.Bool
+	token  atomic.Uint64
+	// until defines when leader steps down unless lease is renewed, in unix nanoseconds
+	until atomic.Int64
+}
+
+// NewElection returns new election candidate
+func (l *Locker) NewElection(config ElectionConfig) *Election {
+	if config.TTL == 0 {
+		config.TTL = 15 * time.Second
+	}
+	return &Election{locker: l, config: config}
+}
+
+// NewElection returns new election candidate using connection's locker
+func (c *Conn) NewElection(ctx context.Context, config ElectionConfig) (*Election, error) {
+	l, err := c.Locker(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return l.NewElection(config), nil
+}
+
+// IsLeader checks whether candidate is leader at the moment
+func (e *Election) IsLeader() bool {
+	return e.leader.Load() && e.locker.clock.Now().UnixNano() < e.until.Load()
+}
+
+// renewed moves leader's step down time to the third of the lease before its expiration
+func (e *Election) renewed() {
+	e.until.Store(e.lock.Expires().Add(-e.config.TTL / 3).UnixNano())
+}
+
+// Token returns fencing token of the current leadership, zero if candidate isn't leader
+func (e *Election) Token() uint64 {
+	return e.token.Load()
+}
+
+func (e *Election) elected(lock *Lock) {
+	e.lock = lock
+	e.renewed()
+	e.token.Store(lock.Token())
+	e.leader.Store(true)
+	log.Info().Str("election", e.config.Name).Uint64("token", lock.Token()).Msg("nats: elected as leader")
+	if e.config.OnElected != nil {
+		e.config.OnElected(lock.Token())
+	}
+}
+
+func (e *Election) lost() {
+	e.lock = nil
+	e.leader.Store(false)
+	e.token.Store(0)
+	log.Warn().Str("election", e.config.Name).Msg("nats: leadership lost")
+	if e.config.OnLost != nil {
+		e.config.OnLost()
+	}
+}
+
+// step renews the lease of the leader or tries to acquire the lock otherwise
+func (e *Election) step(ctx context.Context) {
+	if e.lock != nil {
+		err := e.lock.Refresh(ctx)
+		if err == nil {
+			e.renewed()
+			return
+		}
+		log.Error().Err(err).Str("election", e.config.Name).Msg("nats: lease renewal failed")
+		// transient failure: stay leader while enough of the lease is left,
+		// the margin covers clock skew and the time till the next step
+		if errors.Is(err, ErrLockLost) || !e.IsLeader() {
+			e.lost()
+		}
+		return
+	}
+	lock, err := e.locker.Lock(ctx, e.config.Name, e.config.TTL)
+	if err == nil {
+		e.elected(lock)
+		return
+	}
+	if !errors.Is(err, ErrLocked) {
+		log.Error().Err(err).Str("election", e.config.Name).Msg("nats: election failed")
+	}
+}
+
+// Run campaigns for leadership until given context is done, leader resigns then
+func (e *Election) Run(ctx context.Context) {
+	ticker := time.NewTicker(e.config.TTL / 3)
+	defer ticker.Stop()
+	for {
+		e.step(ctx)
+		select {
+		case <-ctx.Done():
+			if e.lock != nil {
+				resignCtx, cancel := context.WithTimeout(context.Background(), e.config.TTL/3)
+				if err := e.lock.Unlock(resignCtx); err != nil {
+					log.Error().Err(err).Str("election", e.config.Name).Msg("nats: resignation failed")
+				}
+				cancel()
+				e.lost()
+			}
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// OnlyLeader wraps given tick function so that it runs only while candidate is leader
+func OnlyLeader(e *Election, fn timeutil.TickFn) timeutil.TickFn {
+	return func() {
+		if e.IsLeader() {
+			fn()
+		}
+	}
+}
+
+// NewLeaderTimer returns fixed timer whose tick function runs only while candidate is leader
+func NewLeaderTimer(e *Election, dur time.Duration, fn timeutil.TickFn) timeutil.Timer {
+	return timeutil.NewFixedTimer(dur, OnlyLeader(e, fn))
+}
@@ -0,0 +1,249 @@
+package natsutil_test
+
+import (
+	"context"
+	"errors"
+	"maps"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go/jetstream"
+
+	"github.com/avakarev/go-util/natsutil"
+	"github.com/avakarev/go-util/testutil"
+	"github.com/avakarev/go-util/timeutil"
+)
+
+// memBucket implements in-memory key-value bucket with revision checks,
+// entries are created at server's wall time, unrelated to the clock of lockers
+type memBucket struct {
+	jetstream.KeyValue
+	mu   sync.Mutex
+	rev  uint64
+	keys map[string]*kvEntry
+	// err fails updates, e.g. to simulate unavailable server
+	err      error
+	watchers []chan jetstream.KeyValueEntry
+}
+
+func newMemBucket() *memBucket {
+	return &memBucket{keys: make(map[string]*kvEntry)}
+}
+
+func (b *memBucket) put(key string, value []byte, op jetstream.KeyValueOp) uint64 {
+	b.rev++
+	b.keys[key] = &kvEntry{key: key, value: string(value), rev: b.rev, op: op, created: time.Now()}
+	for _, w := range b.watchers {
+		w <- b.keys[key]
+	}
+	return b.rev
+}
+
+// memWatcher implements jetstream.KeyWatcher of memBucket, updates are delivered synchronously
+type memWatcher chan jetstream.KeyValueEntry
+
+func (w memWatcher) Updates() <-chan jetstream.KeyValueEntry { return w }
+func (w memWatcher) Stop() error                             { return nil }
+
+func (b *memBucket) WatchAll(_ context.Context, _ ...jetstream.WatchOpt) (jetstream.KeyWatcher, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	w := make(chan jetstream.KeyValueEntry, 256)
+	keys := slices.Sorted(maps.Keys(b.keys))
+	for _, key := range keys {
+		if e := b.keys[key]; e.op == jetstream.KeyValuePut {
+			w <- e
+		}
+	}
+	w <- nil
+	b.watchers = append(b.watchers, w)
+	return memWatcher(w), nil
+}
+
+func (b *memBucket) Get(_ context.Context, key string) (jetstream.KeyValueEntry, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	e, ok := b.keys[key]
+	if !ok || e.op != jetstream.KeyValuePut {
+		return nil, jetstream.ErrKeyNotFound
+	}
+	return e, nil
+}
+
+func (b *memBucket) Create(_ context.Context, key string, value []byte, _ ...jetstream.KVCreateOpt) (uint64, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if e, ok := b.keys[key]; ok && e.op == jetstream.KeyValuePut {
+		return 0, jetstream.ErrKeyExists
+	}
+	return b.put(key, value, jetstream.KeyValuePut), nil
+}
+
+func (b *memBucket) Update(_ context.Context, key string, value []byte, revision uint64) (uint64, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.err != nil {
+		return 0, b.err
+	}
+	if e, ok := b.keys[key]; !ok || e.rev != revision {
+		return 0, jetstream.ErrKeyExists
+	}
+	return b.put(key, value, jetstream.KeyValuePut), nil
+}
+
+func (b *memBucket) Put(_ context.Context, key string, value []byte) (uint64, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.put(key, value, jetstream.KeyValuePut), nil
+}
+
+func (b *memBucket) Keys(_ context.Context, _ ...jetstream.WatchOpt) ([]string, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	keys := make([]string, 0, len(b.keys))
+	for key, e := range b.keys {
+		if e.op == jetstream.KeyValuePut {
+			keys = append(keys, key)
+		}
+	}
+	if len(keys) == 0 {
+		return nil, jetstream.ErrNoKeysFound
+	}
+	slices.Sort(keys)
+	return keys, nil
+}
+
+func (b *memBucket) Delete(_ context.Context, key string, _ ...jetstream.KVDeleteOpt) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.put(key, nil, jetstream.KeyValueDelete)
+	return nil
+}
+
+func TestLock(t *testing.T) {
+	ctx := context.Background()
+	clock := timeutil.NewMock()
+	bucket := newMemBucket()
+	a := natsutil.NewLocker(bucket, clock)
+	b := natsutil.NewLocker(bucket, clock)
+
+	lockA, err := a.Lock(ctx, "reports", time.Minute)
+	testutil.MustNoErr(err, t)
+	_, err = b.Lock(ctx, "reports", time.Minute)
+	testutil.Diff(true, errors.Is(err, natsutil.ErrLocked), t)
+
+	// refresh extends lease and keeps fencing token
+	clock.Add(50 * time.Second)
+	testutil.MustNoErr(lockA.Refresh(ctx), t)
+	clock.Add(50 * time.Second)
+	_, err = b.Lock(ctx, "reports", time.Minute)
+	testutil.Diff(true, errors.Is(err, natsutil.ErrLocked), t)
+
+	// expired lease is taken over with greater fencing token
+	clock.Add(time.Minute)
+	lockB, err := b.Lock(ctx, "reports", time.Minute)
+	testutil.MustNoErr(err, t)
+	testutil.Diff(true, lockB.Token() > lockA.Token(), t)
+	testutil.Diff(true, errors.Is(lockA.Refresh(ctx), natsutil.ErrLockLost), t)
+
+	testutil.MustNoErr(lockB.Unlock(ctx), t)
+	_, err = a.Lock(ctx, "reports", time.Minute)
+	testutil.MustNoErr(err, t)
+}
+
+func TestElection(t *testing.T) {
+	clock := timeutil.NewMock()
+	bucket := newMemBucket()
+
+	var mu sync.Mutex
+	events := make([]string, 0)
+	candidate := func(name string) *natsutil.Election {
+		return natsutil.NewLocker(bucket, clock).NewElection(natsutil.ElectionConfig{
+			Name: "scheduler",
+			TTL:  30 * time.Millisecond,
+			OnElected: func(uint64) {
+				mu.Lock()
+				defer mu.Unlock()
+				events = append(events, name+" elected")
+			},
+			OnLost: func() {
+				mu.Lock()
+				defer mu.Unlock()
+				events = append(events, name+" lost")
+			},
+		})
+	}
+	a, b := candidate("a"), candidate("b")
+
+	ctxA, cancelA := context.WithCancel(context.Background())
+	doneA := make(chan struct{})
+	go func() { a.Run(ctxA); close(doneA) }()
+	waitFor(t, a.IsLeader)
+
+	ctxB, cancelB := context.WithCancel(context.Background())
+	defer cancelB()
+	go b.Run(ctxB)
+	time.Sleep(50 * time.Millisecond)
+	testutil.Diff(false, b.IsLeader(), t)
+
+	ticks := 0
+	natsutil.OnlyLeader(b, func() { ticks++ })()
+	natsutil.OnlyLeader(a, func() { ticks++ })()
+	testutil.Diff(1, ticks, t)
+
+	cancelA()
+	<-doneA
+	waitFor(t, b.IsLeader)
+	testutil.Diff(true, b.Token() > 0, t)
+	testutil.Diff(uint64(0), a.Token(), t)
+
+	mu.Lock()
+	defer mu.Unlock()
+	testutil.Diff([]string{"a elected", "a lost", "b elected"}, events, t)
+}
+
+func TestElectionStepDown(t *testing.T) {
+	clock := timeutil.NewMock()
+	bucket := newMemBucket()
+	var lost atomic.Bool
+	e := natsutil.NewLocker(bucket, clock).NewElection(natsutil.ElectionConfig{
+		Name:   "scheduler",
+		TTL:    30 * time.Millisecond,
+		OnLost: func() { lost.Store(true) },
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go e.Run(ctx)
+	waitFor(t, e.IsLeader)
+
+	bucket.mu.Lock()
+	bucket.err = errors.New("nats: timeout")
+	bucket.mu.Unlock()
+
+	// failed renewals are tolerated while more than third of the lease is left
+	clock.Add(15 * time.Millisecond)
+	time.Sleep(30 * time.Millisecond)
+	testutil.Diff(true, e.IsLeader(), t)
+	testutil.Diff(false, lost.Load(), t)
+
+	// leader steps down before its lease expires, so that next one can't overlap with it
+	clock.Add(10 * time.Millisecond)
+	testutil.Diff(false, e.IsLeader(), t)
+	waitFor(t, lost.Load)
+	_, err := natsutil.NewLocker(bucket, clock).Lock(context.Background(), "scheduler", time.Second)
+	testutil.Diff(true, errors.Is(err, natsutil.ErrLocked), t)
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("condition is not met in time")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
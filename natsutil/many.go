@@ -0,0 +1,264 @@
+package natsutil
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"iter"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/nats-io/nats.go"
+
+	"github.com/avakarev/go-util/httputil"
+)
+
+const (
+	// StreamSeqHeader carries sequence number of the streamed chunk, starting from 1
+	StreamSeqHeader = "Nats-Stream-Seq"
+	// StreamEOFHeader marks the last message of the stream, it carries no data
+	StreamEOFHeader = "Nats-Stream-EOF"
+)
+
+// ManyOptions defines when RequestMany stops collecting replies,
+// it always stops once context or client timeout is reached
+type ManyOptions struct {
+	// Max defines max number of replies, zero means unlimited
+	Max int
+	// Stall defines how long to wait for next reply after the first one, zero means until timeout
+	Stall time.Duration
+	// Sentinel returns true for reply which marks the end, sentinel reply itself isn't collected
+	Sentinel func(msg *nats.Msg) bool
+}
+
+// ReplySource defines subscription to request's reply inbox
+type ReplySource interface {
+	// Next returns next reply, nats.ErrNoResponders means that nobody received the request
+	Next(ctx context.Context) (*nats.Msg, error)
+	// Close stops receiving replies
+	Close()
+}
+
+// InboxReplies returns source of replies received by given subscription to request's reply inbox
+func InboxReplies(sub *nats.Subscription) ReplySource {
+	return &subSource{sub: sub}
+}
+
+type subSource struct {
+	sub *nats.Subscription
+}
+
+func (s *subSource) Next(ctx context.Context) (*nats.Msg, error) {
+	msg, err := s.sub.NextMsgWithContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	// server replies with status-only message if there are no subscribers
+	if len(msg.Data) == 0 && msg.Header.Get("Status") == "503" {
+		return nil, nats.ErrNoResponders
+	}
+	return msg, nil
+}
+
+func (s *subSource) Close() {
+	_ = s.sub.Unsubscribe()
+}
+
+// RequestPublisher publishes request with reply inbox and returns source of its replies,
+// it lets buses other than Conn implement RequestMany and RequestStream, see CollectReplies and StreamReplies
+type RequestPublisher func(ctx context.Context, msg *nats.Msg, config *ClientConfig) (ReplySource, error)
+
+func (c *Conn) publishRequest(ctx context.Context, msg *nats.Msg, config *ClientConfig) (ReplySource, error) {
+	msg.Subject = NamespaceSubject(c.subjectNamer(), config.Env, msg.Subject)
+	msg.Reply = c.conn.NewInbox()
+	if msg.Header == nil {
+		msg.Header = make(nats.Header)
+	}
+	InjectHeader(ctx, msg.Header)
+	sub, err := c.conn.SubscribeSync(msg.Reply)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.conn.PublishMsg(msg); err != nil {
+		_ = sub.Unsubscribe()
+		return nil, fmt.Errorf("%w, subj=%q", err, msg.Subject)
+	}
+	return InboxReplies(sub), nil
+}
+
+// NewRequestMsg returns request message with given value encoded by given codec, nil value means empty payload
+func NewRequestMsg(subj string, v any, codec Codec) (*nats.Msg, error) {
+	msg := nats.NewMsg(subj)
+	if v == nil {
+		return msg, nil
+	}
+	if err := EncodeMsg(msg, codec, v); err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
+// CollectReplies publishes request with given publisher and collects replies, see Conn.RequestMany
+func CollectReplies(ctx context.Context, publish RequestPublisher, msg *nats.Msg, opts *ManyOptions, config *ClientConfig) ([]*nats.Msg, error) {
+	if opts == nil {
+		opts = &ManyOptions{}
+	}
+	if _, ok := ctx.Deadline(); !ok && config.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, config.Timeout)
+		defer cancel()
+	}
+	src, err := publish(ctx, msg, config)
+	if err != nil {
+		return nil, err
+	}
+	defer src.Close()
+
+	replies := make([]*nats.Msg, 0)
+	for opts.Max <= 0 || len(replies) < opts.Max {
+		waitCtx, cancel := ctx, context.CancelFunc(func() {})
+		if opts.Stall > 0 && len(replies) > 0 {
+			waitCtx, cancel = context.WithTimeout(ctx, opts.Stall)
+		}
+		reply, err := src.Next(waitCtx)
+		cancel()
+		if errors.Is(err, nats.ErrNoResponders) {
+			return nil, fmt.Errorf("%w, subj=%q", TransportErr(err), msg.Subject)
+		}
+		if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, nats.ErrTimeout) {
+			break
+		}
+		if err != nil {
+			return replies, err
+		}
+		if opts.Sentinel != nil && opts.Sentinel(reply) {
+			break
+		}
+		replies = append(replies, reply)
+	}
+	return replies, nil
+}
+
+// RequestMany sends request and collects replies of multiple responders until max count,
+// stall or sentinel of given options is reached, or context or client timeout expires.
+// Error replies are collected as is, see ReplyErr.
+func (c *Conn) RequestMany(ctx context.Context, subj string, v any, opts *ManyOptions, clientOpts ...ClientOption) ([]*nats.Msg, error) {
+	config := c.ClientConfig(clientOpts...)
+	msg, err := NewRequestMsg(subj, v, config.PayloadCodec())
+	if err != nil {
+		return nil, err
+	}
+	return CollectReplies(ctx, c.publishRequest, msg, opts, config)
+}
+
+// StreamReplies publishes request with given publisher and iterates over streamed chunks, see Conn.RequestStream
+func StreamReplies(ctx context.Context, publish RequestPublisher, msg *nats.Msg, config *ClientConfig) iter.Seq2[*nats.Msg, error] {
+	return func(yield func(*nats.Msg, error) bool) {
+		src, err := publish(ctx, msg, config)
+		if err != nil {
+			yield(nil, err)
+			return
+		}
+		defer src.Close()
+
+		for seq := 1; ; seq++ {
+			waitCtx, cancel := ctx, context.CancelFunc(func() {})
+			if config.Timeout > 0 {
+				waitCtx, cancel = context.WithTimeout(ctx, config.Timeout)
+			}
+			chunk, err := src.Next(waitCtx)
+			cancel()
+			if err != nil {
+				yield(nil, fmt.Errorf("%w, subj=%q", TransportErr(err), msg.Subject))
+				return
+			}
+			if err := ReplyErr(chunk); err != nil {
+				yield(nil, err)
+				return
+			}
+			if got := chunk.Header.Get(StreamSeqHeader); got != strconv.Itoa(seq) {
+				yield(nil, &httputil.NewErr(http.StatusBadGateway, fmt.Sprintf("stream chunk %d is missing, got %q", seq, got)).Error)
+				return
+			}
+			if chunk.Header.Get(StreamEOFHeader) != "" {
+				return
+			}
+			if !yield(chunk, nil) {
+				return
+			}
+		}
+	}
+}
+
+// RequestStream sends request and iterates over chunks streamed by responder until end of stream.
+// Client config's timeout limits wait for every chunk. Error reply or missing chunk stops iteration with error.
+func (c *Conn) RequestStream(ctx context.Context, subj string, v any, opts ...ClientOption) iter.Seq2[*nats.Msg, error] {
+	config := c.ClientConfig(opts...)
+	msg, err := NewRequestMsg(subj, v, config.PayloadCodec())
+	if err != nil {
+		return func(yield func(*nats.Msg, error) bool) { yield(nil, err) }
+	}
+	return StreamReplies(ctx, c.publishRequest, msg, config)
+}
+
+// StreamWriter streams chunked reply to the request
+type StreamWriter struct {
+	bus   Bus
+	reply string
+	seq   int
+}
+
+// NewStreamWriter returns writer streaming reply to the given request message
+func NewStreamWriter(bus Bus, msg *nats.Msg) (*StreamWriter, error) {
+	if msg.Reply == "" {
+		return nil, nats.ErrMsgNoReply
+	}
+	return &StreamWriter{bus: bus, reply: msg.Reply}, nil
+}
+
+func (w *StreamWriter) send(ctx context.Context, chunk *nats.Msg) error {
+	w.seq++
+	chunk.Subject = Abs(w.reply)
+	chunk.Header.Set(StreamSeqHeader, strconv.Itoa(w.seq))
+	return w.bus.PublishMsg(ctx, chunk)
+}
+
+// Write sends given bytes as next chunk
+func (w *StreamWriter) Write(ctx context.Context, data []byte) error {
+	chunk := nats.NewMsg("")
+	chunk.Data = data
+	return w.send(ctx, chunk)
+}
+
+// WriteJSON sends given value encoded to JSON as next chunk
+func (w *StreamWriter) WriteJSON(ctx context.Context, v any) error {
+	bytes, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return w.Write(ctx, bytes)
+}
+
+// Close sends end of stream marker
+func (w *StreamWriter) Close(ctx context.Context) error {
+	chunk := nats.NewMsg("")
+	chunk.Header.Set(StreamEOFHeader, "1")
+	return w.send(ctx, chunk)
+}
+
+// CloseWithError sends given error as the last chunk, requester's iteration stops with it
+func (w *StreamWriter) CloseWithError(ctx context.Context, err error) error {
+	resp := httputil.NewErrFrom(err)
+	bytes, err := json.Marshal(resp)
+	if err != nil {
+		return err
+	}
+	chunk := nats.NewMsg("")
+	chunk.Header.Set(ContentTypeHeader, JSONCodec.ContentType())
+	chunk.Header.Set(ErrHeader, resp.Error.Msg)
+	chunk.Header.Set(ErrCodeHeader, strconv.Itoa(resp.Error.Code))
+	chunk.Data = bytes
+	return w.send(ctx, chunk)
+}
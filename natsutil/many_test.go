@@ -0,0 +1,97 @@
+package natsutil_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+
+	"github.com/avakarev/go-util/httputil"
+	"github.com/avakarev/go-util/natsutil"
+	"github.com/avakarev/go-util/natsutil/natstest"
+	"github.com/avakarev/go-util/testutil"
+)
+
+func TestRequestMany(t *testing.T) {
+	bus := natstest.NewFakeBus("dev")
+	for _, name := range []string{"a", "b", "c"} {
+		testutil.MustNoErr(bus.Subscribe("inventory.count", func(msg *nats.Msg) error {
+			return natsutil.Respond(msg, []byte(name))
+		}), t)
+	}
+	requestMany := func(subj string, opts *natsutil.ManyOptions) ([]string, error) {
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		replies, err := bus.RequestMany(ctx, subj, nil, opts)
+		got := make([]string, len(replies))
+		for i, msg := range replies {
+			got[i] = string(msg.Data)
+		}
+		return got, err
+	}
+
+	replies, err := requestMany("inventory.count", nil)
+	testutil.MustNoErr(err, t)
+	testutil.Diff([]string{"a", "b", "c"}, replies, t)
+
+	replies, err = requestMany("inventory.count", &natsutil.ManyOptions{Max: 2})
+	testutil.MustNoErr(err, t)
+	testutil.Diff([]string{"a", "b"}, replies, t)
+
+	replies, err = requestMany("inventory.count", &natsutil.ManyOptions{
+		Sentinel: func(msg *nats.Msg) bool { return string(msg.Data) == "b" },
+	})
+	testutil.MustNoErr(err, t)
+	testutil.Diff([]string{"a"}, replies, t)
+
+	_, err = requestMany("inventory.unknown", nil)
+	testutil.MustErr(errors.New(`503: service unavailable: nats: no responders available for request, subj="dev.inventory.unknown"`), err, t)
+}
+
+func TestRequestStream(t *testing.T) {
+	bus := natstest.NewFakeBus("dev")
+	testutil.MustNoErr(bus.SubscribeContext("orders.export", func(ctx context.Context, msg *nats.Msg) error {
+		w, err := natsutil.NewStreamWriter(bus, msg)
+		if err != nil {
+			return err
+		}
+		for _, id := range []string{"1", "2", "3"} {
+			if err := w.WriteJSON(ctx, order{ID: id}); err != nil {
+				return err
+			}
+		}
+		if string(msg.Data) == `"fail"` {
+			return w.CloseWithError(ctx, &httputil.NewErr(500, "export failed").Error)
+		}
+		return w.Close(ctx)
+	}), t)
+
+	ids := make([]string, 0)
+	for chunk, err := range bus.RequestStream(context.Background(), "orders.export", nil) {
+		testutil.MustNoErr(err, t)
+		var o order
+		testutil.MustNoErr(json.Unmarshal(chunk.Data, &o), t)
+		ids = append(ids, o.ID)
+	}
+	testutil.Diff([]string{"1", "2", "3"}, ids, t)
+
+	var got error
+	chunks := 0
+	for _, err := range bus.RequestStream(context.Background(), "orders.export", "fail") {
+		if err != nil {
+			got = err
+			break
+		}
+		chunks++
+	}
+	testutil.Diff(3, chunks, t)
+	testutil.MustErr(errors.New("500: export failed"), got, t)
+
+	// breaking iteration early is fine
+	for range bus.RequestStream(context.Background(), "orders.export", nil) {
+		break
+	}
+}
@@ -0,0 +1,173 @@
+package natsutil
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"runtime/debug"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+
+	"github.com/avakarev/go-util/httputil"
+)
+
+// Middleware defines handler wrapper, e.g. for logging, recovery or metrics
+type Middleware func(next MsgContextHandlerFunc) MsgContextHandlerFunc
+
+// Chain wraps given handler with given middlewares, first middleware is the outermost one
+func Chain(fn MsgContextHandlerFunc, mws ...Middleware) MsgContextHandlerFunc {
+	for _, mw := range slices.Backward(mws) {
+		fn = mw(fn)
+	}
+	return fn
+}
+
+// Use appends given middlewares to the connection's chain.
+// Connection's middlewares wrap handlers subscribed after the call and run before per-subscription ones.
+func (c *Conn) Use(mws ...Middleware) {
+	c.middlewares = append(c.middlewares, mws...)
+}
+
+// chain wraps given handler with connection's and given per-subscription middlewares
+func (c *Conn) chain(fn MsgContextHandlerFunc, mws []Middleware) MsgContextHandlerFunc {
+	return Chain(fn, append(slices.Clone(c.middlewares), mws...)...)
+}
+
+// Recover returns middleware which turns handler's panic into error
+func Recover() Middleware {
+	return func(next MsgContextHandlerFunc) MsgContextHandlerFunc {
+		return func(ctx context.Context, msg *nats.Msg) (err error) {
+			defer func() {
+				if r := recover(); r != nil {
+					log.Error().Str("subject", msg.Subject).Bytes("stack", debug.Stack()).Msgf("nats: panic: %v", r)
+					err = fmt.Errorf("panic: %v", r)
+				}
+			}()
+			return next(ctx, msg)
+		}
+	}
+}
+
+// AccessLog returns middleware which logs every handled message with its subject, duration and outcome.
+// Successful messages are logged with given level, failed ones with warn (4xx) or error level.
+// Context's logger is used, so that entries carry request id and trace id.
+func AccessLog(level zerolog.Level) Middleware {
+	return func(next MsgContextHandlerFunc) MsgContextHandlerFunc {
+		return func(ctx context.Context, msg *nats.Msg) error {
+			start := time.Now()
+			err := next(ctx, msg)
+			logger := ctxLogger(ctx)
+			e := logger.WithLevel(level)
+			if err != nil {
+				code := httputil.NewErrFrom(err).Error.Code
+				if code < http.StatusInternalServerError {
+					e = logger.Warn()
+				} else {
+					e = logger.Error()
+				}
+				e = e.Err(err).Int("code", code)
+			}
+			e.Str("subject", msg.Subject).
+				Bool("request", msg.Reply != "").
+				Int("size", len(msg.Data)).
+				Dur("duration", time.Since(start)).
+				Msg("nats: handled")
+			return err
+		}
+	}
+}
+
+// MetricsObserver defines receiver of handler metrics
+type MetricsObserver interface {
+	// Observe records handling of the message with given subject
+	Observe(subj string, duration time.Duration, err error)
+}
+
+// Metrics returns middleware which reports handling latency of every message to given observer
+func Metrics(observer MetricsObserver) Middleware {
+	return func(next MsgContextHandlerFunc) MsgContextHandlerFunc {
+		return func(ctx context.Context, msg *nats.Msg) error {
+			start := time.Now()
+			err := next(ctx, msg)
+			observer.Observe(msg.Subject, time.Since(start), err)
+			return err
+		}
+	}
+}
+
+// LatencyStat defines latency statistics of a single subject
+type LatencyStat struct {
+	Count  uint64        `json:"count"`
+	Errors uint64        `json:"errors"`
+	Total  time.Duration `json:"total"`
+	Min    time.Duration `json:"min"`
+	Max    time.Duration `json:"max"`
+}
+
+// Avg returns average latency
+func (s LatencyStat) Avg() time.Duration {
+	if s.Count == 0 {
+		return 0
+	}
+	return s.Total / time.Duration(s.Count)
+}
+
+// LatencyStats implements in-memory MetricsObserver aggregating latencies per subject
+type LatencyStats struct {
+	mu    sync.Mutex
+	stats map[string]LatencyStat
+}
+
+// Observe records handling of the message with given subject
+func (s *LatencyStats) Observe(subj string, duration time.Duration, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.stats == nil {
+		s.stats = make(map[string]LatencyStat)
+	}
+	stat := s.stats[subj]
+	if stat.Count == 0 || duration < stat.Min {
+		stat.Min = duration
+	}
+	stat.Max = max(stat.Max, duration)
+	stat.Count++
+	stat.Total += duration
+	if err != nil {
+		stat.Errors++
+	}
+	s.stats[subj] = stat
+}
+
+// Snapshot returns copy of collected stats by subject
+func (s *LatencyStats) Snapshot() map[string]LatencyStat {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	cp := make(map[string]LatencyStat, len(s.stats))
+	for subj, stat := range s.stats {
+		cp[subj] = stat
+	}
+	return cp
+}
+
+// ConcurrencyLimit returns middleware which limits number of messages handled at the same time.
+// Limit is shared by all subscriptions the middleware is applied to.
+// Message waits for a free slot until its context is done and is rejected with 503 error then.
+func ConcurrencyLimit(n int) Middleware {
+	sem := make(chan struct{}, n)
+	return func(next MsgContextHandlerFunc) MsgContextHandlerFunc {
+		return func(ctx context.Context, msg *nats.Msg) error {
+			select {
+			case sem <- struct{}{}:
+			case <-ctx.Done():
+				return fmt.Errorf("%w: %w", &httputil.NewErr(http.StatusServiceUnavailable, "concurrency limit reached").Error, ctx.Err())
+			}
+			defer func() { <-sem }()
+			return next(ctx, msg)
+		}
+	}
+}
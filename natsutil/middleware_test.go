@@ -0,0 +1,81 @@
+package natsutil_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+
+	"github.com/avakarev/go-util/httputil"
+	"github.com/avakarev/go-util/natsutil"
+	"github.com/avakarev/go-util/testutil"
+)
+
+func TestChain(t *testing.T) {
+	calls := make([]string, 0)
+	mw := func(name string) natsutil.Middleware {
+		return func(next natsutil.MsgContextHandlerFunc) natsutil.MsgContextHandlerFunc {
+			return func(ctx context.Context, msg *nats.Msg) error {
+				calls = append(calls, name)
+				return next(ctx, msg)
+			}
+		}
+	}
+	fn := natsutil.Chain(func(context.Context, *nats.Msg) error {
+		calls = append(calls, "handler")
+		return nil
+	}, mw("first"), mw("second"))
+	testutil.MustNoErr(fn(context.Background(), &nats.Msg{}), t)
+	testutil.Diff([]string{"first", "second", "handler"}, calls, t)
+}
+
+func TestRecover(t *testing.T) {
+	fn := natsutil.Chain(func(context.Context, *nats.Msg) error {
+		panic("boom")
+	}, natsutil.Recover())
+	testutil.MustErr(errors.New("panic: boom"), fn(context.Background(), &nats.Msg{Subject: "dev.test"}), t)
+}
+
+func TestMetrics(t *testing.T) {
+	stats := &natsutil.LatencyStats{}
+	fn := natsutil.Chain(func(_ context.Context, msg *nats.Msg) error {
+		if len(msg.Data) == 0 {
+			return errors.New("empty")
+		}
+		return nil
+	}, natsutil.Metrics(stats))
+	testutil.MustNoErr(fn(context.Background(), &nats.Msg{Subject: "dev.test", Data: []byte("{}")}), t)
+	testutil.MustErr(errors.New("empty"), fn(context.Background(), &nats.Msg{Subject: "dev.test"}), t)
+
+	stat := stats.Snapshot()["dev.test"]
+	testutil.Diff(uint64(2), stat.Count, t)
+	testutil.Diff(uint64(1), stat.Errors, t)
+	testutil.Diff(true, stat.Min <= stat.Avg() && stat.Avg() <= stat.Max, t)
+}
+
+func TestConcurrencyLimit(t *testing.T) {
+	release := make(chan struct{})
+	started := make(chan struct{})
+	fn := natsutil.Chain(func(context.Context, *nats.Msg) error {
+		close(started)
+		<-release
+		return nil
+	}, natsutil.ConcurrencyLimit(1))
+
+	done := make(chan error)
+	go func() { done <- fn(context.Background(), &nats.Msg{}) }()
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	err := fn(ctx, &nats.Msg{})
+	var e *httputil.Err
+	testutil.Diff(true, errors.As(err, &e), t)
+	testutil.Diff(503, e.Code, t)
+	testutil.Diff(true, errors.Is(err, context.DeadlineExceeded), t)
+
+	close(release)
+	testutil.MustNoErr(<-done, t)
+}
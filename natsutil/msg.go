@@ -1,6 +1,8 @@
 package natsutil
 
 import (
+	"context"
+
 	"github.com/nats-io/nats.go"
 	"github.com/rs/zerolog/log"
 )
@@ -8,6 +10,15 @@ import (
 // MsgHandlerFunc defines callback function that processes messages delivered to asynchronous subscribers
 type MsgHandlerFunc func(msg *nats.Msg) error
 
+func (fn MsgHandlerFunc) withContext() MsgContextHandlerFunc {
+	return func(_ context.Context, msg *nats.Msg) error {
+		return fn(msg)
+	}
+}
+
+// MsgContextHandlerFunc defines callback function that processes messages within per-message context
+type MsgContextHandlerFunc func(ctx context.Context, msg *nats.Msg) error
+
 // ErrHandlerFunc defines error handlers invoked in case when MsgHandlerFunc returns error
 type ErrHandlerFunc func(msg *nats.Msg, err error)
 
@@ -24,3 +35,15 @@ func DefaultErrHandler(msg *nats.Msg, err error) {
 		log.Error().Err(err).Str("subject", msg.Subject).Msg("nats: error handler failed")
 	}
 }
+
+// CopyMsg returns copy of given message which isn't bound to subscription, headers are copied deeply
+func CopyMsg(msg *nats.Msg) *nats.Msg {
+	cp := &nats.Msg{Subject: msg.Subject, Reply: msg.Reply, Data: msg.Data}
+	if msg.Header != nil {
+		cp.Header = make(nats.Header, len(msg.Header))
+		for k, v := range msg.Header {
+			cp.Header[k] = append([]string(nil), v...)
+		}
+	}
+	return cp
+}
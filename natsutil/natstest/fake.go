@@ -0,0 +1,373 @@
+// Package natstest implements natsutil test helpers, e.g. in-memory bus
+package natstest
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"iter"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nats.go"
+
+	"github.com/avakarev/go-util/envutil"
+	"github.com/avakarev/go-util/natsutil"
+	"github.com/avakarev/go-util/testutil"
+)
+
+type fakeSub struct {
+	pattern string
+	queue   string
+	sub     *nats.Subscription
+	handler nats.MsgHandler
+}
+
+// FakeBus implements in-memory natsutil.Bus for unit tests.
+// Messages are delivered synchronously, subjects are namespaced same way as by natsutil.Conn.
+// It supports wildcards, queue groups (round-robin) and request/reply.
+// Delivered messages are bound to the connection to in-process embedded server, so that handlers reply as usual,
+// e.g. with natsutil.Respond or msg.Respond; replies reach requests through the server.
+type FakeBus struct {
+	mu          sync.Mutex
+	env         envutil.AppEnv
+	namer       natsutil.SubjectNamer
+	client      *natsutil.ClientConfig
+	server      *server.Server
+	conn        *nats.Conn
+	subs        []*fakeSub
+	queues      map[string]int
+	published   []*nats.Msg
+	middlewares []natsutil.Middleware
+	// HandlerTimeout defines deadline of handler's context
+	HandlerTimeout time.Duration
+	ErrHandler     natsutil.ErrHandlerFunc
+}
+
+var _ natsutil.Bus = (*FakeBus)(nil)
+
+// NewFakeBus returns new in-memory bus for given env
+func NewFakeBus(env envutil.AppEnv) *FakeBus {
+	client := natsutil.DefaultClientConfig()
+	client.Env = env.String()
+	return &FakeBus{
+		env:            env,
+		namer:          natsutil.DefaultNamer,
+		client:         client,
+		queues:         make(map[string]int),
+		HandlerTimeout: client.Timeout,
+		ErrHandler:     natsutil.DefaultErrHandler,
+	}
+}
+
+// connect returns bus's connection to in-process embedded server, server is started once needed
+func (f *FakeBus) connect() (*nats.Conn, error) {
+	if f.conn != nil {
+		return f.conn, nil
+	}
+	s, err := server.NewServer(&server.Options{DontListen: true, NoLog: true, NoSigs: true})
+	if err != nil {
+		return nil, err
+	}
+	go s.Start()
+	if !s.ReadyForConnections(5 * time.Second) {
+		s.Shutdown()
+		return nil, errors.New("natstest: embedded server isn't ready")
+	}
+	conn, err := nats.Connect("", nats.InProcessServer(s), nats.Name("natstest"))
+	if err != nil {
+		s.Shutdown()
+		return nil, err
+	}
+	f.server, f.conn = s, conn
+	return conn, nil
+}
+
+// Close closes bus's connection and shuts embedded server down
+func (f *FakeBus) Close() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.conn != nil {
+		f.conn.Close()
+		f.server.Shutdown()
+		f.conn, f.server = nil, nil
+	}
+}
+
+// Use appends given middlewares to the chain of handlers subscribed afterwards
+func (f *FakeBus) Use(mws ...natsutil.Middleware) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.middlewares = append(f.middlewares, mws...)
+}
+
+// deliver records given message and synchronously hands it over to matching subscribers,
+// it returns number of deliveries
+func (f *FakeBus) deliver(msg *nats.Msg) int {
+	f.mu.Lock()
+	f.published = append(f.published, natsutil.CopyMsg(msg))
+	targets := make([]*fakeSub, 0)
+	groups := make(map[string][]*fakeSub)
+	for _, sub := range f.subs {
+		if !natsutil.MatchSubject(sub.pattern, msg.Subject) {
+			continue
+		}
+		if sub.queue == "" {
+			targets = append(targets, sub)
+			continue
+		}
+		groups[sub.queue] = append(groups[sub.queue], sub)
+	}
+	for queue, members := range groups {
+		targets = append(targets, members[f.queues[queue]%len(members)])
+		f.queues[queue]++
+	}
+	f.mu.Unlock()
+
+	for _, sub := range targets {
+		m := natsutil.CopyMsg(msg)
+		m.Sub = sub.sub
+		sub.handler(m)
+	}
+	return len(targets)
+}
+
+// publish delivers given message, message sent to request's inbox, e.g. streamed reply, goes through embedded server
+func (f *FakeBus) publish(msg *nats.Msg) error {
+	if !strings.HasPrefix(msg.Subject, nats.InboxPrefix) {
+		f.deliver(msg)
+		return nil
+	}
+	f.mu.Lock()
+	conn, err := f.connect()
+	f.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	return conn.PublishMsg(msg)
+}
+
+// Publish sends byte slice to the given subject
+func (f *FakeBus) Publish(subj string, data []byte) error {
+	return f.publish(&nats.Msg{Subject: natsutil.NamespaceSubject(f.namer, f.env.String(), subj), Data: data})
+}
+
+// PublishJSON marshals given value into JSON and sends to the given subject
+func (f *FakeBus) PublishJSON(subj string, v any) error {
+	bytes, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return f.Publish(subj, bytes)
+}
+
+// PublishEncoded encodes given value with client config's codec and sends to the given subject
+func (f *FakeBus) PublishEncoded(ctx context.Context, subj string, v any, opts ...natsutil.ClientOption) error {
+	msg := nats.NewMsg(subj)
+	if err := natsutil.EncodeMsg(msg, f.ClientConfig(opts...).PayloadCodec(), v); err != nil {
+		return err
+	}
+	return f.PublishMsg(ctx, msg)
+}
+
+// PublishMsg sends given message, propagating request id and trace context via headers
+func (f *FakeBus) PublishMsg(ctx context.Context, msg *nats.Msg) error {
+	msg.Subject = natsutil.NamespaceSubject(f.namer, f.env.String(), msg.Subject)
+	if msg.Header == nil {
+		msg.Header = make(nats.Header)
+	}
+	natsutil.InjectHeader(ctx, msg.Header)
+	return f.publish(msg)
+}
+
+func (f *FakeBus) subscribe(subj string, queue string, fn natsutil.MsgContextHandlerFunc, mws []natsutil.Middleware) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	conn, err := f.connect()
+	if err != nil {
+		return err
+	}
+	pattern := natsutil.NamespaceSubject(f.namer, f.env.String(), subj)
+	// subscription just binds delivered messages to the connection, nothing is received through it
+	sub, err := conn.QueueSubscribeSync(pattern, queue)
+	if err != nil {
+		return fmt.Errorf("%w, subj=%q", err, pattern)
+	}
+	fn = natsutil.Chain(fn, append(append([]natsutil.Middleware{}, f.middlewares...), mws...)...)
+	f.subs = append(f.subs, &fakeSub{
+		pattern: pattern,
+		queue:   queue,
+		sub:     sub,
+		handler: func(msg *nats.Msg) {
+			ctx, cancel := context.WithTimeout(context.Background(), f.HandlerTimeout)
+			defer cancel()
+			if err := fn(natsutil.ContextFromHeader(ctx, msg.Header), msg); err != nil {
+				f.ErrHandler(msg, err)
+			}
+		},
+	})
+	return nil
+}
+
+// Subscribe subscribes given handler to the given subject
+func (f *FakeBus) Subscribe(subj string, fn natsutil.MsgHandlerFunc, mws ...natsutil.Middleware) error {
+	return f.subscribe(subj, "", func(_ context.Context, msg *nats.Msg) error {
+		return fn(msg)
+	}, mws)
+}
+
+// SubscribeContext subscribes given context-aware handler to the given subject
+func (f *FakeBus) SubscribeContext(subj string, fn natsutil.MsgContextHandlerFunc, mws ...natsutil.Middleware) error {
+	return f.subscribe(subj, "", fn, mws)
+}
+
+// QueueSubscribe subscribes given handler to the given subject as a member of the queue group
+func (f *FakeBus) QueueSubscribe(subj string, queue string, fn nats.MsgHandler, mws ...natsutil.Middleware) error {
+	return f.subscribe(subj, queue, func(_ context.Context, msg *nats.Msg) error {
+		fn(msg)
+		return nil
+	}, mws)
+}
+
+// QueueSubscribeContext subscribes given context-aware handler to the given subject as a member of the queue group
+func (f *FakeBus) QueueSubscribeContext(subj string, queue string, fn natsutil.MsgContextHandlerFunc, mws ...natsutil.Middleware) error {
+	return f.subscribe(subj, queue, fn, mws)
+}
+
+// ClientConfig returns bus's client config with given options applied
+func (f *FakeBus) ClientConfig(opts ...natsutil.ClientOption) *natsutil.ClientConfig {
+	return natsutil.ClientConfigure(f.client, opts)
+}
+
+// Subject returns given request subject namespaced with client config's env
+func (f *FakeBus) Subject(subj string, opts ...natsutil.ClientOption) string {
+	return natsutil.NamespaceSubject(f.namer, f.ClientConfig(opts...).Env, subj)
+}
+
+// publishRequest delivers request with reply inbox, replies sent by handlers arrive through embedded server
+func (f *FakeBus) publishRequest(ctx context.Context, msg *nats.Msg, config *natsutil.ClientConfig) (natsutil.ReplySource, error) {
+	f.mu.Lock()
+	conn, err := f.connect()
+	f.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	msg.Subject = natsutil.NamespaceSubject(f.namer, config.Env, msg.Subject)
+	msg.Reply = conn.NewInbox()
+	if msg.Header == nil {
+		msg.Header = make(nats.Header)
+	}
+	natsutil.InjectHeader(ctx, msg.Header)
+	sub, err := conn.SubscribeSync(msg.Reply)
+	if err != nil {
+		return nil, err
+	}
+	if f.deliver(msg) == 0 {
+		_ = sub.Unsubscribe()
+		return nil, fmt.Errorf("%w, subj=%q", natsutil.TransportErr(nats.ErrNoResponders), msg.Subject)
+	}
+	return natsutil.InboxReplies(sub), nil
+}
+
+// RequestContext sends request and returns reply's message
+func (f *FakeBus) RequestContext(ctx context.Context, subj string, v any, opts ...natsutil.ClientOption) (*nats.Msg, error) {
+	msg, err := natsutil.NewRequestMsg(subj, v, f.ClientConfig(opts...).PayloadCodec())
+	if err != nil {
+		return nil, err
+	}
+	return f.RequestMsg(ctx, msg, opts...)
+}
+
+// RequestMsg sends given message as request and returns reply's message.
+// Error reply is returned as *httputil.Err error.
+func (f *FakeBus) RequestMsg(ctx context.Context, msg *nats.Msg, opts ...natsutil.ClientOption) (*nats.Msg, error) {
+	config := f.ClientConfig(opts...)
+	if _, ok := ctx.Deadline(); !ok && config.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, config.Timeout)
+		defer cancel()
+	}
+	src, err := f.publishRequest(ctx, msg, config)
+	if err != nil {
+		return nil, err
+	}
+	defer src.Close()
+	reply, err := src.Next(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("%w, subj=%q", natsutil.TransportErr(err), msg.Subject)
+	}
+	if err := natsutil.ReplyErr(reply); err != nil {
+		return nil, err
+	}
+	return reply, nil
+}
+
+// RequestMany sends request and collects replies of multiple responders, see natsutil.Conn.RequestMany
+func (f *FakeBus) RequestMany(ctx context.Context, subj string, v any, opts *natsutil.ManyOptions, clientOpts ...natsutil.ClientOption) ([]*nats.Msg, error) {
+	config := f.ClientConfig(clientOpts...)
+	msg, err := natsutil.NewRequestMsg(subj, v, config.PayloadCodec())
+	if err != nil {
+		return nil, err
+	}
+	return natsutil.CollectReplies(ctx, f.publishRequest, msg, opts, config)
+}
+
+// RequestStream sends request and iterates over streamed chunks, see natsutil.Conn.RequestStream
+func (f *FakeBus) RequestStream(ctx context.Context, subj string, v any, opts ...natsutil.ClientOption) iter.Seq2[*nats.Msg, error] {
+	config := f.ClientConfig(opts...)
+	msg, err := natsutil.NewRequestMsg(subj, v, config.PayloadCodec())
+	if err != nil {
+		return func(yield func(*nats.Msg, error) bool) { yield(nil, err) }
+	}
+	return natsutil.StreamReplies(ctx, f.publishRequest, msg, config)
+}
+
+// Published returns messages published to subjects matching given subject or wildcard pattern
+func (f *FakeBus) Published(subj string) []*nats.Msg {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	pattern := natsutil.NamespaceSubject(f.namer, f.env.String(), subj)
+	msgs := make([]*nats.Msg, 0)
+	for _, msg := range f.published {
+		if natsutil.MatchSubject(pattern, msg.Subject) {
+			msgs = append(msgs, msg)
+		}
+	}
+	return msgs
+}
+
+// Reset forgets published messages
+func (f *FakeBus) Reset() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.published = nil
+}
+
+// MustPublished decodes the last message published to given subject and compares it with given value
+func (f *FakeBus) MustPublished(subj string, want any, t *testing.T) {
+	t.Helper()
+	msgs := f.Published(subj)
+	if len(msgs) == 0 {
+		t.Errorf("Nothing was published to %q", subj)
+		return
+	}
+	got := reflect.New(reflect.TypeOf(want))
+	if err := natsutil.DecodeMsg(msgs[len(msgs)-1], got.Interface()); err != nil {
+		t.Errorf("Published to %q message is malformed: %s", subj, err)
+		return
+	}
+	testutil.Diff(want, got.Elem().Interface(), t)
+}
+
+// MustNotPublished fails the test if anything was published to given subject
+func (f *FakeBus) MustNotPublished(subj string, t *testing.T) {
+	t.Helper()
+	if msgs := f.Published(subj); len(msgs) > 0 {
+		t.Errorf("Unexpectedly published %d message(s) to %q", len(msgs), subj)
+	}
+}
@@ -0,0 +1,125 @@
+package natstest_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+
+	"github.com/avakarev/go-util/httputil"
+	"github.com/avakarev/go-util/natsutil"
+	"github.com/avakarev/go-util/natsutil/natstest"
+	"github.com/avakarev/go-util/testutil"
+)
+
+type order struct {
+	ID    string `json:"id" validate:"required"`
+	Total int    `json:"total" validate:"gte=0"`
+}
+
+func TestFakeBusPublish(t *testing.T) {
+	bus := natstest.NewFakeBus("dev")
+	got := make([]string, 0)
+	testutil.MustNoErr(bus.Subscribe("orders.*", func(msg *nats.Msg) error {
+		got = append(got, msg.Sub.Subject+" "+msg.Subject)
+		return nil
+	}), t)
+	for _, worker := range []string{"a", "b"} {
+		testutil.MustNoErr(bus.QueueSubscribe("orders.>", "workers", func(msg *nats.Msg) {
+			got = append(got, worker+" "+msg.Subject)
+		}), t)
+	}
+
+	testutil.MustNoErr(bus.PublishJSON("orders.created", order{ID: "1"}), t)
+	testutil.MustNoErr(bus.PublishJSON("orders.created", order{ID: "2"}), t)
+	testutil.MustNoErr(bus.Publish("invoices.created", nil), t)
+
+	testutil.Diff([]string{
+		"dev.orders.* dev.orders.created",
+		"a dev.orders.created",
+		"dev.orders.* dev.orders.created",
+		"b dev.orders.created",
+	}, got, t)
+	testutil.Diff(2, len(bus.Published("orders.>")), t)
+	bus.MustPublished("orders.created", order{ID: "2"}, t)
+	bus.MustNotPublished("orders.deleted", t)
+
+	bus.Reset()
+	bus.MustNotPublished("orders.created", t)
+}
+
+func TestFakeBusPublishNested(t *testing.T) {
+	bus := natstest.NewFakeBus("dev")
+	defer bus.Close()
+	got := make([]string, 0)
+	testutil.MustNoErr(bus.Subscribe("orders.created", func(*nats.Msg) error {
+		got = append(got, "order")
+		// nested publish is delivered before the outer one returns
+		return bus.Publish("invoices.created", nil)
+	}), t)
+	testutil.MustNoErr(bus.Subscribe("invoices.created", func(*nats.Msg) error {
+		got = append(got, "invoice")
+		return nil
+	}), t)
+
+	testutil.MustNoErr(bus.Publish("orders.created", nil), t)
+	testutil.Diff([]string{"order", "invoice"}, got, t)
+	testutil.Diff(2, len(bus.Published(">")), t)
+}
+
+func TestFakeBusRequest(t *testing.T) {
+	bus := natstest.NewFakeBus("dev")
+	testutil.MustNoErr(natsutil.Handle(bus, "orders.create", func(ctx context.Context, o order) (order, error) {
+		if o.ID == "dup" {
+			return order{}, &httputil.NewErr(409, "order exists").Error
+		}
+		o.Total++
+		return o, nil
+	}), t)
+	testutil.MustNoErr(bus.Subscribe("orders.silent", func(*nats.Msg) error { return nil }), t)
+
+	resp, err := natsutil.Call[order, order](bus, "orders.create", order{ID: "1", Total: 1})
+	testutil.MustNoErr(err, t)
+	testutil.Diff(order{ID: "1", Total: 2}, resp, t)
+
+	cases := []struct {
+		subj string
+		req  order
+		code int
+		msg  string
+	}{
+		{subj: "orders.create", req: order{ID: "dup"}, code: 409, msg: "order exists"},
+		{subj: "orders.create", req: order{Total: -1}, code: 400, msg: "validation error"},
+		{subj: "orders.unknown", req: order{ID: "1"}, code: 503, msg: "service unavailable"},
+		{subj: "orders.silent", req: order{ID: "1"}, code: 504, msg: "gateway timeout"},
+	}
+	for _, tt := range cases {
+		_, err := natsutil.Call[order, order](bus, tt.subj, tt.req, natsutil.WithTimeout(10*time.Millisecond))
+		var e *httputil.Err
+		testutil.Diff(true, errors.As(err, &e), t)
+		testutil.Diff(tt.code, e.Code, t)
+		testutil.Diff(tt.msg, e.Msg, t)
+	}
+}
+
+func TestFakeBusRespondMsg(t *testing.T) {
+	bus := natstest.NewFakeBus("dev")
+	defer bus.Close()
+	testutil.MustNoErr(bus.Subscribe("orders.get", func(msg *nats.Msg) error {
+		reply := nats.NewMsg("")
+		reply.Header.Set("traceparent", "00-abc-01")
+		reply.Header.Add("X-Tags", "a")
+		reply.Header.Add("X-Tags", "b")
+		reply.Data = []byte(`{"id":"1"}`)
+		return msg.RespondMsg(reply)
+	}), t)
+
+	reply, err := bus.RequestContext(context.Background(), "orders.get", nil)
+	testutil.MustNoErr(err, t)
+	testutil.Diff("00-abc-01", reply.Header.Get("traceparent"), t)
+	testutil.Diff([]string{"a", "b"}, reply.Header.Values("X-Tags"), t)
+	testutil.Diff(`{"id":"1"}`, string(reply.Data), t)
+	testutil.Diff(1, len(bus.Published(">")), t) // the request, reply goes to inbox
+}
@@ -2,13 +2,30 @@
 package natsutil
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
 
 	"github.com/nats-io/nats.go"
 
 	"github.com/avakarev/go-util/httputil"
 )
 
+const (
+	// ErrHeader is a header carrying error message of error reply
+	ErrHeader = "Nats-Service-Error"
+	// ErrCodeHeader is a header carrying error code of error reply
+	ErrCodeHeader = "Nats-Service-Error-Code"
+)
+
+// RespondMsg responds given reply message, e.g. with headers
+func RespondMsg(msg *nats.Msg, reply *nats.Msg) error {
+	return msg.RespondMsg(reply)
+}
+
 // Respond responds given bytes
 func Respond(msg *nats.Msg, bytes []byte) error {
 	return msg.Respond(bytes)
@@ -23,7 +40,59 @@ func RespondJSON(msg *nats.Msg, v any) error {
 	return Respond(msg, bytes)
 }
 
-// RespondJSONErr responds given error value as marshalled bytes
+// RespondJSONErr responds given error value as marshalled bytes.
+// Reply is marked as error by ErrHeader and ErrCodeHeader headers.
 func RespondJSONErr(msg *nats.Msg, err error) error {
-	return RespondJSON(msg, httputil.NewErrFrom(err))
+	reply, err := newErrReply(msg.Reply, err)
+	if err != nil {
+		return err
+	}
+	return RespondMsg(msg, reply)
+}
+
+// newErrReply returns error reply to the given subject with error headers and JSON body
+func newErrReply(subj string, err error) (*nats.Msg, error) {
+	resp := httputil.NewErrFrom(err)
+	bytes, err := json.Marshal(resp)
+	if err != nil {
+		return nil, err
+	}
+	reply := nats.NewMsg(subj)
+	reply.Header.Set(ContentTypeHeader, JSONCodec.ContentType())
+	reply.Header.Set(ErrHeader, resp.Error.Msg)
+	reply.Header.Set(ErrCodeHeader, strconv.Itoa(resp.Error.Code))
+	reply.Data = bytes
+	return reply, nil
+}
+
+// ReplyErr returns *httputil.Err if given reply is marked as error, nil otherwise
+func ReplyErr(msg *nats.Msg) error {
+	if msg.Header == nil {
+		return nil
+	}
+	codeStr := msg.Header.Get(ErrCodeHeader)
+	if codeStr == "" {
+		return nil
+	}
+	var resp httputil.ErrResponse
+	if err := json.Unmarshal(msg.Data, &resp); err == nil && resp.Error.Code != 0 {
+		return &resp.Error
+	}
+	code, err := strconv.Atoi(codeStr)
+	if err != nil {
+		code = http.StatusInternalServerError
+	}
+	return &httputil.Err{Code: code, Msg: msg.Header.Get(ErrHeader)}
+}
+
+// TransportErr wraps nats request error with matching *httputil.Err,
+// so that errors.Is still matches the original error
+func TransportErr(err error) error {
+	switch {
+	case errors.Is(err, nats.ErrNoResponders):
+		return fmt.Errorf("%w: %w", &httputil.NewErr(http.StatusServiceUnavailable, "").Error, err)
+	case errors.Is(err, nats.ErrTimeout), errors.Is(err, context.DeadlineExceeded):
+		return fmt.Errorf("%w: %w", &httputil.NewErr(http.StatusGatewayTimeout, "").Error, err)
+	}
+	return err
 }
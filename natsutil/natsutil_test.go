@@ -0,0 +1,83 @@
+package natsutil_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nats.go"
+
+	"github.com/avakarev/go-util/httputil"
+	"github.com/avakarev/go-util/natsutil"
+	"github.com/avakarev/go-util/testutil"
+)
+
+// runServer starts embedded jetstream-enabled server, it's shut down on test cleanup
+func runServer(t *testing.T, fns ...func(opts *server.Options)) *server.Server {
+	t.Helper()
+	opts := &server.Options{
+		Host:      "127.0.0.1",
+		Port:      -1,
+		JetStream: true,
+		StoreDir:  t.TempDir(),
+		NoLog:     true,
+		NoSigs:    true,
+	}
+	for _, fn := range fns {
+		fn(opts)
+	}
+	s, err := server.NewServer(opts)
+	testutil.MustNoErr(err, t)
+	go s.Start()
+	if !s.ReadyForConnections(5 * time.Second) {
+		t.Fatal("nats server isn't ready")
+	}
+	t.Cleanup(s.Shutdown)
+	return s
+}
+
+// newConn returns dev connection to given server, it's closed on test cleanup
+func newConn(t *testing.T, s *server.Server, fns ...func(config *natsutil.ConnConfig)) *natsutil.Conn {
+	t.Helper()
+	config := &natsutil.ConnConfig{Env: "dev", URL: s.ClientURL(), NakDelay: 50 * time.Millisecond}
+	for _, fn := range fns {
+		fn(config)
+	}
+	c, err := natsutil.NewConn(config)
+	testutil.MustNoErr(err, t)
+	t.Cleanup(func() { _ = c.Close() })
+	return c
+}
+
+// waitInterest waits until server knows whether given subjects have subscribers,
+// subscriptions of one connection reach the server asynchronously to requests of another one
+func waitInterest(t *testing.T, s *server.Server, interest bool, subjs ...string) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for _, subj := range subjs {
+		for s.GlobalAccount().SubscriptionInterest(subj) != interest {
+			if time.Now().After(deadline) {
+				t.Fatalf("interest in %q isn't %t", subj, interest)
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+}
+
+func TestReplyErr(t *testing.T) {
+	msg := nats.NewMsg("")
+	msg.Data = []byte(`{"error":{"code":400,"msg":"fake"}}`)
+	testutil.Diff(nil, natsutil.ReplyErr(msg), t)
+
+	msg.Header.Set(natsutil.ErrHeader, "validation error")
+	msg.Header.Set(natsutil.ErrCodeHeader, "400")
+	msg.Data = []byte(`{"error":{"code":400,"msg":"validation error","items":[{"subject":"name","msg":"required but missing"}]}}`)
+	testutil.Diff(&httputil.Err{
+		Code:  400,
+		Msg:   "validation error",
+		Items: []httputil.ValidationErr{{Subject: "name", Msg: "required but missing"}},
+	}, natsutil.ReplyErr(msg), t)
+
+	msg.Data = []byte("not a json")
+	testutil.Diff(&httputil.Err{Code: 400, Msg: "validation error"}, natsutil.ReplyErr(msg), t)
+}
@@ -0,0 +1,378 @@
+package natsutil
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/rs/zerolog/log"
+
+	"github.com/avakarev/go-util/httputil"
+	"github.com/avakarev/go-util/timeutil"
+)
+
+// ErrBreakerOpen is returned when request is rejected by open circuit breaker
+var ErrBreakerOpen = errors.New("circuit breaker is open")
+
+// BreakerState represents circuit breaker state
+type BreakerState string
+
+const (
+	// BreakerClosed is breaker's state letting all requests through
+	BreakerClosed BreakerState = "closed"
+	// BreakerOpen is breaker's state rejecting all requests
+	BreakerOpen BreakerState = "open"
+	// BreakerHalfOpen is breaker's state letting limited number of trial requests through
+	BreakerHalfOpen BreakerState = "half-open"
+)
+
+// String returns state's string representation
+func (s BreakerState) String() string {
+	return string(s)
+}
+
+// BreakerConfig defines circuit breaker settings
+type BreakerConfig struct {
+	// FailureThreshold defines number of consecutive failures opening the breaker, defaults to 5
+	FailureThreshold int
+	// OpenTimeout defines how long breaker stays open before letting trial requests through, defaults to 30s
+	OpenTimeout time.Duration
+	// HalfOpenRequests defines number of successful trial requests closing the breaker, defaults to 1
+	HalfOpenRequests int
+	// IsFailure checks whether request's error counts as failure,
+	// defaults to transport and server (5xx) errors except cancelled requests, see Retryable.
+	// Request cancelled by the caller is never counted, it tells nothing about the downstream.
+	IsFailure func(err error) bool
+}
+
+// Breaker implements circuit breaker: it opens after consecutive failures and rejects requests,
+// once open timeout passes it lets trial requests through and closes when they succeed
+type Breaker struct {
+	mu        sync.Mutex
+	name      string
+	config    BreakerConfig
+	clock     timeutil.Clock
+	state     BreakerState
+	failures  int
+	successes int
+	trials    int
+	openedAt  time.Time
+	onChange  func(name string, from BreakerState, to BreakerState)
+}
+
+// NewBreaker returns closed circuit breaker with given name, nil clock means wall clock
+func NewBreaker(name string, config BreakerConfig, clock timeutil.Clock) *Breaker {
+	if config.FailureThreshold <= 0 {
+		config.FailureThreshold = 5
+	}
+	if config.OpenTimeout <= 0 {
+		config.OpenTimeout = 30 * time.Second
+	}
+	if config.HalfOpenRequests <= 0 {
+		config.HalfOpenRequests = 1
+	}
+	if config.IsFailure == nil {
+		config.IsFailure = isFailure
+	}
+	if clock == nil {
+		clock = timeutil.NewClock()
+	}
+	return &Breaker{name: name, config: config, clock: clock, state: BreakerClosed}
+}
+
+// State returns breaker's current state
+func (b *Breaker) State() BreakerState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.refresh()
+	return b.state
+}
+
+// setState switches breaker to given state, it must be called with lock held
+func (b *Breaker) setState(state BreakerState) {
+	from := b.state
+	if from == state {
+		return
+	}
+	b.state = state
+	b.failures, b.successes, b.trials = 0, 0, 0
+	if state == BreakerOpen {
+		b.openedAt = b.clock.Now()
+	}
+	log.Warn().Str("breaker", b.name).Str("from", from.String()).Str("to", state.String()).Msg("nats: circuit breaker state changed")
+	if b.onChange != nil {
+		b.onChange(b.name, from, state)
+	}
+}
+
+// refresh switches open breaker to half-open once open timeout passes, it must be called with lock held
+func (b *Breaker) refresh() {
+	if b.state == BreakerOpen && b.clock.Since(b.openedAt) >= b.config.OpenTimeout {
+		b.setState(BreakerHalfOpen)
+	}
+}
+
+// Allow checks whether request may be sent, it fails with ErrBreakerOpen wrapped into 503 *httputil.Err.
+// Every allowed request must be followed by Record.
+func (b *Breaker) Allow() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.refresh()
+	switch {
+	case b.state == BreakerOpen,
+		b.state == BreakerHalfOpen && b.trials >= b.config.HalfOpenRequests:
+		return fmt.Errorf("%w: %w, name=%q", &httputil.NewErr(http.StatusServiceUnavailable, "").Error, ErrBreakerOpen, b.name)
+	case b.state == BreakerHalfOpen:
+		b.trials++
+	}
+	return nil
+}
+
+// isFailure is default BreakerConfig's check
+func isFailure(err error) bool {
+	return !errors.Is(err, context.Canceled) && Retryable(err)
+}
+
+// Record records outcome of the allowed request, cancelled request is neither failure nor success
+func (b *Breaker) Record(err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if errors.Is(err, context.Canceled) {
+		// trial is given back, so that another request probes the downstream
+		if b.state == BreakerHalfOpen && b.trials > 0 {
+			b.trials--
+		}
+		return
+	}
+	failed := err != nil && b.config.IsFailure(err)
+	switch b.state {
+	case BreakerClosed:
+		if !failed {
+			b.failures = 0
+			return
+		}
+		b.failures++
+		if b.failures >= b.config.FailureThreshold {
+			b.setState(BreakerOpen)
+		}
+	case BreakerHalfOpen:
+		if failed {
+			b.setState(BreakerOpen)
+			return
+		}
+		b.successes++
+		if b.successes >= b.config.HalfOpenRequests {
+			b.setState(BreakerClosed)
+		}
+	}
+}
+
+// latencyWindow keeps latencies of the recent successful requests
+type latencyWindow struct {
+	mu      sync.Mutex
+	samples []time.Duration
+	next    int
+}
+
+const latencyWindowSize = 128
+
+func (w *latencyWindow) observe(d time.Duration) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if len(w.samples) < latencyWindowSize {
+		w.samples = append(w.samples, d)
+		return
+	}
+	w.samples[w.next] = d
+	w.next = (w.next + 1) % latencyWindowSize
+}
+
+// percentile returns latency of given percentile, false if there are fewer samples than given minimum
+func (w *latencyWindow) percentile(p float64, minSamples int) (time.Duration, bool) {
+	w.mu.Lock()
+	sorted := slices.Clone(w.samples)
+	w.mu.Unlock()
+	if len(sorted) == 0 || len(sorted) < minSamples {
+		return 0, false
+	}
+	slices.Sort(sorted)
+	i := int(math.Ceil(p*float64(len(sorted)))) - 1
+	return sorted[max(0, min(i, len(sorted)-1))], true
+}
+
+// ResilienceConfig defines ResilientClient settings
+type ResilienceConfig struct {
+	// Breaker defines settings of per-subject circuit breakers
+	Breaker BreakerConfig
+	// Retry defines retries of idempotent requests, zero Attempts means no retries
+	Retry RetryPolicy
+	// HedgePercentile enables hedging of idempotent requests: if there's no reply within given percentile
+	// of the subject's recent latencies, e.g. 0.95, second request is sent and the first reply wins.
+	// It pays off only if there are multiple responders, e.g. queue group members.
+	HedgePercentile float64
+	// HedgeMinSamples defines number of latency samples required for hedging, defaults to 20
+	HedgeMinSamples int
+	// Clock defaults to wall clock
+	Clock timeutil.Clock
+	// OnStateChange is called when circuit breaker of the subject changes its state,
+	// it's called synchronously and must not use the breaker
+	OnStateChange func(subj string, from BreakerState, to BreakerState)
+}
+
+// ResilientClient wraps bus requests with per-subject circuit breakers.
+// Requests marked with WithIdempotent are also retried and hedged according to config.
+// It implements Bus, so that it can be passed to Call; all other operations are delegated to wrapped bus.
+type ResilientClient struct {
+	Bus
+	config    ResilienceConfig
+	mu        sync.Mutex
+	breakers  map[string]*Breaker
+	latencies map[string]*latencyWindow
+}
+
+var _ Bus = (*ResilientClient)(nil)
+
+// NewResilientClient returns resilient client wrapping given bus
+func NewResilientClient(bus Bus, config ResilienceConfig) *ResilientClient {
+	if config.Clock == nil {
+		config.Clock = timeutil.NewClock()
+	}
+	if config.HedgeMinSamples <= 0 {
+		config.HedgeMinSamples = 20
+	}
+	if config.Retry.Backoff == nil {
+		config.Retry.Backoff = ConstantBackoff(0)
+	}
+	if config.Retry.Retryable == nil {
+		config.Retry.Retryable = Retryable
+	}
+	return &ResilientClient{
+		Bus:       bus,
+		config:    config,
+		breakers:  make(map[string]*Breaker),
+		latencies: make(map[string]*latencyWindow),
+	}
+}
+
+// Breaker returns circuit breaker of the given namespaced subject, see Bus.Subject
+func (r *ResilientClient) Breaker(subj string) *Breaker {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	b, ok := r.breakers[subj]
+	if !ok {
+		b = NewBreaker(subj, r.config.Breaker, r.config.Clock)
+		b.onChange = r.config.OnStateChange
+		r.breakers[subj] = b
+	}
+	return b
+}
+
+func (r *ResilientClient) latency(subj string) *latencyWindow {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	w, ok := r.latencies[subj]
+	if !ok {
+		w = &latencyWindow{}
+		r.latencies[subj] = w
+	}
+	return w
+}
+
+// RequestContext sends request encoded with wrapped bus's codec and returns reply's message, see RequestMsg
+func (r *ResilientClient) RequestContext(ctx context.Context, subj string, v any, opts ...ClientOption) (*nats.Msg, error) {
+	msg, err := NewRequestMsg(subj, v, r.ClientConfig(opts...).PayloadCodec())
+	if err != nil {
+		return nil, err
+	}
+	return r.RequestMsg(ctx, msg, opts...)
+}
+
+// RequestMsg sends given message as request unless subject's circuit breaker is open.
+// Breakers and latencies are tracked per namespaced subject, so that envs don't share them.
+// Idempotent requests are retried on retryable errors and hedged once enough latency samples are collected.
+func (r *ResilientClient) RequestMsg(ctx context.Context, msg *nats.Msg, opts ...ClientOption) (*nats.Msg, error) {
+	subj := r.Subject(msg.Subject, opts...)
+	idempotent := r.ClientConfig(opts...).Idempotent
+	attempts := 1
+	if idempotent && r.config.Retry.Attempts > 1 {
+		attempts = r.config.Retry.Attempts
+	}
+	breaker := r.Breaker(subj)
+	for attempt := 1; ; attempt++ {
+		if err := breaker.Allow(); err != nil {
+			return nil, err
+		}
+		start := r.config.Clock.Now()
+		resp, err := r.request(ctx, subj, msg, idempotent, opts)
+		breaker.Record(err)
+		if err == nil {
+			r.latency(subj).observe(r.config.Clock.Since(start))
+			return resp, nil
+		}
+		if attempt >= attempts || !r.config.Retry.Retryable(err) {
+			return nil, err
+		}
+		timer := time.NewTimer(r.config.Retry.Backoff(attempt))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, err
+		case <-timer.C:
+		}
+	}
+}
+
+type requestResult struct {
+	msg *nats.Msg
+	err error
+}
+
+// request sends copy of given message, idempotent request is hedged if subject's latency percentile is known
+func (r *ResilientClient) request(ctx context.Context, subj string, msg *nats.Msg, idempotent bool, opts []ClientOption) (*nats.Msg, error) {
+	var delay time.Duration
+	hedged := false
+	if idempotent && r.config.HedgePercentile > 0 {
+		delay, hedged = r.latency(subj).percentile(r.config.HedgePercentile, r.config.HedgeMinSamples)
+	}
+	if !hedged {
+		return r.Bus.RequestMsg(ctx, CopyMsg(msg), opts...)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	results := make(chan requestResult, 2)
+	send := func() {
+		resp, err := r.Bus.RequestMsg(ctx, CopyMsg(msg), opts...)
+		results <- requestResult{msg: resp, err: err}
+	}
+	go send()
+	pending := 1
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	var first *requestResult
+	for pending > 0 {
+		select {
+		case <-timer.C:
+			if first == nil {
+				log.Debug().Str("subject", subj).Dur("delay", delay).Msg("nats: hedging request")
+				go send()
+				pending++
+			}
+		case res := <-results:
+			pending--
+			if res.err == nil {
+				return res.msg, nil
+			}
+			if first == nil {
+				first = &res
+			}
+		}
+	}
+	return nil, first.err
+}
@@ -0,0 +1,218 @@
+package natsutil_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+
+	"github.com/avakarev/go-util/httputil"
+	"github.com/avakarev/go-util/natsutil"
+	"github.com/avakarev/go-util/natsutil/natstest"
+	"github.com/avakarev/go-util/testutil"
+	"github.com/avakarev/go-util/timeutil"
+)
+
+func TestResilientClientBreaker(t *testing.T) {
+	bus := natstest.NewFakeBus("dev")
+	clock := timeutil.NewMock()
+	changes := make([]string, 0)
+	client := natsutil.NewResilientClient(bus, natsutil.ResilienceConfig{
+		Breaker: natsutil.BreakerConfig{FailureThreshold: 2, OpenTimeout: time.Minute},
+		Clock:   clock,
+		OnStateChange: func(subj string, from natsutil.BreakerState, to natsutil.BreakerState) {
+			changes = append(changes, subj+": "+from.String()+" -> "+to.String())
+		},
+	})
+	calls := 0
+	down := true
+	testutil.MustNoErr(natsutil.Handle(bus, "stock.get", func(ctx context.Context, o order) (order, error) {
+		calls++
+		if o.ID == "missing" {
+			return order{}, &httputil.NewErr(404, "").Error
+		}
+		if down {
+			return order{}, errors.New("db is down")
+		}
+		return o, nil
+	}), t)
+
+	// client errors don't count as failures
+	for range 3 {
+		_, err := natsutil.Call[order, order](client, "stock.get", order{ID: "missing"})
+		testutil.MustErr(errors.New("404: not found"), err, t)
+	}
+	testutil.Diff(natsutil.BreakerClosed, client.Breaker("dev.stock.get").State(), t)
+
+	for range 2 {
+		_, err := natsutil.Call[order, order](client, "stock.get", order{ID: "1"})
+		testutil.MustErr(errors.New("500: db is down"), err, t)
+	}
+	testutil.Diff(natsutil.BreakerOpen, client.Breaker("dev.stock.get").State(), t)
+	_, err := natsutil.Call[order, order](client, "stock.get", order{ID: "1"})
+	testutil.Diff(true, errors.Is(err, natsutil.ErrBreakerOpen), t)
+	var e *httputil.Err
+	testutil.Diff(true, errors.As(err, &e), t)
+	testutil.Diff(503, e.Code, t)
+	testutil.Diff(5, calls, t)
+
+	// failed trial request opens breaker again
+	clock.Add(time.Minute)
+	testutil.Diff(natsutil.BreakerHalfOpen, client.Breaker("dev.stock.get").State(), t)
+	_, err = natsutil.Call[order, order](client, "stock.get", order{ID: "1"})
+	testutil.MustErr(errors.New("500: db is down"), err, t)
+	testutil.Diff(natsutil.BreakerOpen, client.Breaker("dev.stock.get").State(), t)
+
+	clock.Add(time.Minute)
+	down = false
+	resp, err := natsutil.Call[order, order](client, "stock.get", order{ID: "1"})
+	testutil.MustNoErr(err, t)
+	testutil.Diff(order{ID: "1"}, resp, t)
+	testutil.Diff(natsutil.BreakerClosed, client.Breaker("dev.stock.get").State(), t)
+
+	testutil.Diff([]string{
+		"dev.stock.get: closed -> open",
+		"dev.stock.get: open -> half-open",
+		"dev.stock.get: half-open -> open",
+		"dev.stock.get: open -> half-open",
+		"dev.stock.get: half-open -> closed",
+	}, changes, t)
+}
+
+func TestResilientClientRetry(t *testing.T) {
+	bus := natstest.NewFakeBus("dev")
+	client := natsutil.NewResilientClient(bus, natsutil.ResilienceConfig{
+		Retry: natsutil.RetryPolicy{Attempts: 3, Backoff: natsutil.ConstantBackoff(time.Millisecond)},
+	})
+	calls := 0
+	testutil.MustNoErr(bus.Subscribe("stock.get", func(msg *nats.Msg) error {
+		calls++
+		if calls%3 != 0 {
+			return errors.New("db is down")
+		}
+		return natsutil.Respond(msg, []byte("ok"))
+	}), t)
+
+	_, err := client.RequestContext(context.Background(), "stock.get", nil)
+	testutil.MustErr(errors.New("500: db is down"), err, t)
+	testutil.Diff(1, calls, t)
+
+	msg, err := client.RequestContext(context.Background(), "stock.get", nil, natsutil.WithIdempotent())
+	testutil.MustNoErr(err, t)
+	testutil.Diff("ok", string(msg.Data), t)
+	testutil.Diff(3, calls, t)
+}
+
+func TestResilientClientIdempotentBus(t *testing.T) {
+	conn := newConn(t, runServer(t), func(config *natsutil.ConnConfig) {
+		config.Client = &natsutil.ClientConfig{Idempotent: true}
+	})
+	client := natsutil.NewResilientClient(conn, natsutil.ResilienceConfig{
+		Retry: natsutil.RetryPolicy{Attempts: 3, Backoff: natsutil.ConstantBackoff(time.Millisecond)},
+	})
+	var calls atomic.Int32
+	testutil.MustNoErr(conn.Subscribe("stock.get", func(msg *nats.Msg) error {
+		if calls.Add(1)%3 != 0 {
+			return errors.New("db is down")
+		}
+		return natsutil.Respond(msg, []byte("ok"))
+	}), t)
+
+	// requests are idempotent by bus's default, no option is needed
+	msg, err := client.RequestContext(context.Background(), "stock.get", nil)
+	testutil.MustNoErr(err, t)
+	testutil.Diff("ok", string(msg.Data), t)
+	testutil.Diff(int32(3), calls.Load(), t)
+}
+
+func TestResilientClientEnvBreakers(t *testing.T) {
+	bus := natstest.NewFakeBus("dev")
+	client := natsutil.NewResilientClient(bus, natsutil.ResilienceConfig{
+		Breaker: natsutil.BreakerConfig{FailureThreshold: 1, OpenTimeout: time.Minute},
+	})
+	testutil.MustNoErr(bus.Subscribe("stock.get", func(msg *nats.Msg) error {
+		return errors.New("db is down")
+	}), t)
+
+	_, err := client.RequestContext(context.Background(), "stock.get", nil)
+	testutil.MustErr(errors.New("500: db is down"), err, t)
+	testutil.Diff(natsutil.BreakerOpen, client.Breaker("dev.stock.get").State(), t)
+
+	// same subject of another env has its own breaker
+	_, err = client.RequestContext(context.Background(), "stock.get", nil, natsutil.WithEnv("beta"))
+	testutil.Diff(false, errors.Is(err, natsutil.ErrBreakerOpen), t)
+	testutil.Diff(natsutil.BreakerOpen, client.Breaker("beta.stock.get").State(), t)
+	_, err = client.RequestContext(context.Background(), "stock.get", nil)
+	testutil.Diff(true, errors.Is(err, natsutil.ErrBreakerOpen), t)
+}
+
+func TestResilientClientHedging(t *testing.T) {
+	bus := natstest.NewFakeBus("dev")
+	client := natsutil.NewResilientClient(bus, natsutil.ResilienceConfig{
+		HedgePercentile: 0.9,
+		HedgeMinSamples: 1,
+	})
+	bus.ErrHandler = func(*nats.Msg, error) {}
+	var calls atomic.Int32
+	testutil.MustNoErr(bus.Subscribe("stock.get", func(msg *nats.Msg) error {
+		// second request is stuck, its hedge replies immediately
+		if calls.Add(1) == 2 {
+			time.Sleep(time.Second)
+		}
+		return natsutil.Respond(msg, []byte("ok"))
+	}), t)
+
+	for range 2 {
+		start := time.Now()
+		msg, err := client.RequestContext(context.Background(), "stock.get", nil, natsutil.WithIdempotent())
+		testutil.MustNoErr(err, t)
+		testutil.Diff("ok", string(msg.Data), t)
+		testutil.Diff(true, time.Since(start) < 500*time.Millisecond, t)
+	}
+	testutil.Diff(int32(3), calls.Load(), t)
+}
+
+func TestResilientClientCodec(t *testing.T) {
+	conn := newConn(t, runServer(t), func(config *natsutil.ConnConfig) {
+		config.Client = &natsutil.ClientConfig{Codec: natsutil.MsgpackCodec}
+	})
+	// wrapped client uses codec of the underlying bus
+	client := natsutil.NewResilientClient(natsutil.NewResilientClient(conn, natsutil.ResilienceConfig{}), natsutil.ResilienceConfig{})
+	testutil.MustNoErr(natsutil.Handle(conn, "stock.get", func(ctx context.Context, o order) (order, error) {
+		return o, nil
+	}), t)
+
+	msg, err := client.RequestContext(context.Background(), "stock.get", order{ID: "1"})
+	testutil.MustNoErr(err, t)
+	testutil.Diff(natsutil.MsgpackCodec.ContentType(), msg.Header.Get(natsutil.ContentTypeHeader), t)
+	testutil.Diff(natsutil.MsgpackCodec, client.ClientConfig().Codec, t)
+}
+
+func TestBreakerCanceled(t *testing.T) {
+	clock := timeutil.NewMock()
+	breaker := natsutil.NewBreaker("stock.get", natsutil.BreakerConfig{FailureThreshold: 1, OpenTimeout: time.Minute}, clock)
+
+	// cancelled requests don't open breaker
+	for range 3 {
+		testutil.MustNoErr(breaker.Allow(), t)
+		breaker.Record(fmt.Errorf("%w, subj=%q", natsutil.TransportErr(context.Canceled), "stock.get"))
+	}
+	testutil.Diff(natsutil.BreakerClosed, breaker.State(), t)
+
+	testutil.MustNoErr(breaker.Allow(), t)
+	breaker.Record(errors.New("db is down"))
+	testutil.Diff(natsutil.BreakerOpen, breaker.State(), t)
+
+	// cancelled trial lets another one through
+	clock.Add(time.Minute)
+	testutil.MustNoErr(breaker.Allow(), t)
+	breaker.Record(context.Canceled)
+	testutil.Diff(natsutil.BreakerHalfOpen, breaker.State(), t)
+	testutil.MustNoErr(breaker.Allow(), t)
+	breaker.Record(nil)
+	testutil.Diff(natsutil.BreakerClosed, breaker.State(), t)
+}
@@ -0,0 +1,243 @@
+package natsutil
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/rs/zerolog/log"
+
+	"github.com/avakarev/go-util/httputil"
+	"github.com/avakarev/go-util/timeutil"
+)
+
+const (
+	// DeadLetterSubjectHeader carries original subject of the dead-lettered message
+	DeadLetterSubjectHeader = "Nats-Dead-Letter-Subject"
+	// DeadLetterErrorHeader carries error of the last handling attempt
+	DeadLetterErrorHeader = "Nats-Dead-Letter-Error"
+	// DeadLetterAttemptsHeader carries number of handling attempts
+	DeadLetterAttemptsHeader = "Nats-Dead-Letter-Attempts"
+	// DeadLetterTimeHeader carries time when message was dead-lettered, in RFC3339 format
+	DeadLetterTimeHeader = "Nats-Dead-Letter-Time"
+)
+
+// ErrDeadLettered marks errors of messages published to dead-letter subject
+var ErrDeadLettered = errors.New("dead-lettered")
+
+// BackoffFunc returns delay before given retry, first retry is 1
+type BackoffFunc func(retry int) time.Duration
+
+// ConstantBackoff returns backoff with the same delay before every retry
+func ConstantBackoff(delay time.Duration) BackoffFunc {
+	return func(int) time.Duration {
+		return delay
+	}
+}
+
+// ExponentialBackoff returns backoff doubling delay before every retry starting from initial one,
+// zero max means delay isn't capped
+func ExponentialBackoff(initial time.Duration, max time.Duration) BackoffFunc {
+	return func(retry int) time.Duration {
+		delay := initial
+		for i := 1; i < retry; i++ {
+			delay *= 2
+			if max > 0 && delay >= max {
+				return max
+			}
+		}
+		if max > 0 && delay > max {
+			return max
+		}
+		return delay
+	}
+}
+
+// RetryPolicy defines retry settings of failing handlers
+type RetryPolicy struct {
+	// Attempts defines max number of handler calls including the first one, defaults to 3
+	Attempts int
+	// Backoff defines delay before every retry, defaults to no delay
+	Backoff BackoffFunc
+	// Retryable checks whether handler's error is worth retrying,
+	// defaults to any error except ErrTerm and client (4xx) errors
+	Retryable func(err error) bool
+}
+
+// Retryable is default RetryPolicy's check, it rejects ErrTerm and client (4xx) errors
+func Retryable(err error) bool {
+	if errors.Is(err, ErrTerm) {
+		return false
+	}
+	return httputil.NewErrFrom(err).Error.Code >= http.StatusInternalServerError
+}
+
+// RetryErr wraps error of the handler which failed after given number of attempts, its message is the same
+type RetryErr struct {
+	Attempts int
+	Err      error
+}
+
+// Error returns message of the last attempt's error
+func (e *RetryErr) Error() string {
+	return e.Err.Error()
+}
+
+// Unwrap returns error of the last attempt
+func (e *RetryErr) Unwrap() error {
+	return e.Err
+}
+
+// Attempts returns number of handling attempts recorded in given error, 1 if there were no retries
+func Attempts(err error) int {
+	var retryErr *RetryErr
+	if errors.As(err, &retryErr) {
+		return retryErr.Attempts
+	}
+	return 1
+}
+
+// Retry returns middleware which calls handler again while it fails with retryable error.
+// Retries stop once attempts are exhausted or message's context is done, final error is *RetryErr.
+// Note that handler's context deadline limits total time of all attempts.
+func Retry(policy RetryPolicy) Middleware {
+	if policy.Attempts <= 0 {
+		policy.Attempts = 3
+	}
+	if policy.Backoff == nil {
+		policy.Backoff = ConstantBackoff(0)
+	}
+	if policy.Retryable == nil {
+		policy.Retryable = Retryable
+	}
+	return func(next MsgContextHandlerFunc) MsgContextHandlerFunc {
+		return func(ctx context.Context, msg *nats.Msg) error {
+			attempt := 1
+			for {
+				err := next(ctx, msg)
+				if err == nil {
+					return nil
+				}
+				if attempt >= policy.Attempts || !policy.Retryable(err) {
+					return &RetryErr{Attempts: attempt, Err: err}
+				}
+				ctxLogger(ctx).Warn().Err(err).Str("subject", msg.Subject).Int("attempt", attempt).Msg("nats: retrying")
+				timer := time.NewTimer(policy.Backoff(attempt))
+				select {
+				case <-ctx.Done():
+					timer.Stop()
+					return &RetryErr{Attempts: attempt, Err: err}
+				case <-timer.C:
+				}
+				attempt++
+			}
+		}
+	}
+}
+
+// deadLetterErr marks error of dead-lettered message, its message is the same.
+// It wraps ErrTerm so that jetstream message is terminated instead of being redelivered.
+type deadLetterErr struct {
+	err error
+}
+
+func (e *deadLetterErr) Error() string {
+	return e.err.Error()
+}
+
+func (e *deadLetterErr) Unwrap() []error {
+	return []error{ErrDeadLettered, ErrTerm, e.err}
+}
+
+// DeadLetter returns middleware which publishes failed messages to given dead-letter subject.
+// Dead letter keeps original data and headers, original subject, error, attempts and time are added as headers.
+// Handler's error is still returned, so that ErrHandler logs it or replies to requester.
+// Apply it before Retry, so that only messages which fail all attempts are dead-lettered.
+// Dead-letter time is taken from given clock, nil clock means wall clock.
+func DeadLetter(bus Bus, subj string, clock timeutil.Clock) Middleware {
+	if clock == nil {
+		clock = timeutil.NewClock()
+	}
+	return func(next MsgContextHandlerFunc) MsgContextHandlerFunc {
+		return func(ctx context.Context, msg *nats.Msg) error {
+			err := next(ctx, msg)
+			if err == nil {
+				return nil
+			}
+			dead := CopyMsg(msg)
+			dead.Subject = subj
+			dead.Reply = ""
+			if dead.Header == nil {
+				dead.Header = make(nats.Header)
+			}
+			dead.Header.Set(DeadLetterSubjectHeader, msg.Subject)
+			dead.Header.Set(DeadLetterErrorHeader, err.Error())
+			dead.Header.Set(DeadLetterAttemptsHeader, strconv.Itoa(Attempts(err)))
+			dead.Header.Set(DeadLetterTimeHeader, clock.Now().UTC().Format(time.RFC3339Nano))
+			// handler's context may be already done
+			if pubErr := bus.PublishMsg(context.WithoutCancel(ctx), dead); pubErr != nil {
+				ctxLogger(ctx).Error().Err(pubErr).Str("subject", msg.Subject).Msg("nats: dead letter failed")
+				return err
+			}
+			return &deadLetterErr{err: err}
+		}
+	}
+}
+
+// DeadLetterInfo defines dead-letter details of the message
+type DeadLetterInfo struct {
+	Subject  string
+	Error    string
+	Attempts int
+	Time     time.Time
+}
+
+// ParseDeadLetter returns dead-letter details of given message, false if it isn't dead letter
+func ParseDeadLetter(msg *nats.Msg) (*DeadLetterInfo, bool) {
+	if msg.Header == nil || msg.Header.Get(DeadLetterSubjectHeader) == "" {
+		return nil, false
+	}
+	info := &DeadLetterInfo{
+		Subject: msg.Header.Get(DeadLetterSubjectHeader),
+		Error:   msg.Header.Get(DeadLetterErrorHeader),
+	}
+	info.Attempts, _ = strconv.Atoi(msg.Header.Get(DeadLetterAttemptsHeader))
+	info.Time, _ = time.Parse(time.RFC3339Nano, msg.Header.Get(DeadLetterTimeHeader))
+	return info, true
+}
+
+// Replay re-publishes given dead letter to its original subject, dead-letter headers are dropped.
+// Non dead letters are rejected with ErrTerm error.
+func Replay(ctx context.Context, bus Bus, msg *nats.Msg) error {
+	info, ok := ParseDeadLetter(msg)
+	if !ok {
+		return Term(fmt.Errorf("not a dead letter, subj=%q", msg.Subject))
+	}
+	replay := CopyMsg(msg)
+	replay.Subject = Abs(info.Subject)
+	replay.Reply = ""
+	for key := range replay.Header {
+		// jetstream would drop replay as duplicate or reject it on stale expectations
+		if strings.HasPrefix(key, "Nats-Dead-Letter-") || strings.HasPrefix(key, "Nats-Expected-") || key == nats.MsgIdHdr {
+			replay.Header.Del(key)
+		}
+	}
+	if err := bus.PublishMsg(ctx, replay); err != nil {
+		return err
+	}
+	log.Info().Str("subject", info.Subject).Str("error", info.Error).Msg("nats: dead letter replayed")
+	return nil
+}
+
+// ReplayHandler returns handler re-publishing dead letters to their original subjects,
+// e.g. to drain dead-letter subject or jetstream consumer once the failure is fixed
+func ReplayHandler(bus Bus) MsgHandlerFunc {
+	return func(msg *nats.Msg) error {
+		return Replay(context.Background(), bus, msg)
+	}
+}
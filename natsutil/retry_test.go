@@ -0,0 +1,115 @@
+package natsutil_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+
+	"github.com/avakarev/go-util/httputil"
+	"github.com/avakarev/go-util/natsutil"
+	"github.com/avakarev/go-util/natsutil/natstest"
+	"github.com/avakarev/go-util/testutil"
+	"github.com/avakarev/go-util/timeutil"
+)
+
+func TestExponentialBackoff(t *testing.T) {
+	backoff := natsutil.ExponentialBackoff(100*time.Millisecond, time.Second)
+	got := make([]time.Duration, 0)
+	for retry := 1; retry <= 6; retry++ {
+		got = append(got, backoff(retry))
+	}
+	testutil.Diff([]time.Duration{
+		100 * time.Millisecond,
+		200 * time.Millisecond,
+		400 * time.Millisecond,
+		800 * time.Millisecond,
+		time.Second,
+		time.Second,
+	}, got, t)
+}
+
+func TestRetry(t *testing.T) {
+	bus := natstest.NewFakeBus("dev")
+	var errs []error
+	bus.ErrHandler = func(msg *nats.Msg, err error) { errs = append(errs, err) }
+	calls := map[string]int{}
+	retry := natsutil.Retry(natsutil.RetryPolicy{Attempts: 3, Backoff: natsutil.ConstantBackoff(time.Millisecond)})
+
+	testutil.MustNoErr(bus.Subscribe("flaky", func(msg *nats.Msg) error {
+		calls["flaky"]++
+		if calls["flaky"] < 3 {
+			return errors.New("unavailable")
+		}
+		return nil
+	}, retry), t)
+	testutil.MustNoErr(bus.Subscribe("broken", func(msg *nats.Msg) error {
+		calls["broken"]++
+		return errors.New("broken")
+	}, retry), t)
+	testutil.MustNoErr(bus.Subscribe("invalid", func(msg *nats.Msg) error {
+		calls["invalid"]++
+		return &httputil.NewErr(400, "invalid").Error
+	}, retry), t)
+
+	for _, subj := range []string{"flaky", "broken", "invalid"} {
+		testutil.MustNoErr(bus.Publish(subj, nil), t)
+	}
+	testutil.Diff(map[string]int{"flaky": 3, "broken": 3, "invalid": 1}, calls, t)
+	testutil.Diff(2, len(errs), t)
+	testutil.MustErr(errors.New("broken"), errs[0], t)
+	testutil.Diff(3, natsutil.Attempts(errs[0]), t)
+	testutil.Diff(1, natsutil.Attempts(errs[1]), t)
+}
+
+func TestDeadLetter(t *testing.T) {
+	bus := natstest.NewFakeBus("dev")
+	clock := timeutil.NewMock()
+	clock.Set(time.Date(2026, time.January, 15, 10, 30, 0, 0, time.UTC))
+	var handlerErr error
+	bus.ErrHandler = func(msg *nats.Msg, err error) {
+		handlerErr = err
+		natsutil.DefaultErrHandler(msg, err)
+	}
+	fixed := false
+	processed := make([]string, 0)
+	testutil.MustNoErr(bus.Subscribe("orders.created", func(msg *nats.Msg) error {
+		if !fixed {
+			return errors.New("db is down")
+		}
+		processed = append(processed, string(msg.Data))
+		return nil
+	}, natsutil.DeadLetter(bus, "orders.dlq", clock), natsutil.Retry(natsutil.RetryPolicy{Attempts: 2})), t)
+
+	msg := nats.NewMsg("orders.created")
+	msg.Header.Set("X-Tenant", "acme")
+	msg.Data = []byte(`{"id":"1"}`)
+	testutil.MustNoErr(bus.PublishMsg(context.Background(), msg), t)
+
+	testutil.MustErr(errors.New("db is down"), handlerErr, t)
+	testutil.Diff(true, errors.Is(handlerErr, natsutil.ErrDeadLettered), t)
+	testutil.Diff(true, errors.Is(handlerErr, natsutil.ErrTerm), t)
+
+	dead := bus.Published("orders.dlq")
+	testutil.Diff(1, len(dead), t)
+	testutil.Diff(`{"id":"1"}`, string(dead[0].Data), t)
+	testutil.Diff("acme", dead[0].Header.Get("X-Tenant"), t)
+	info, ok := natsutil.ParseDeadLetter(dead[0])
+	testutil.Diff(true, ok, t)
+	testutil.Diff("dev.orders.created", info.Subject, t)
+	testutil.Diff("db is down", info.Error, t)
+	testutil.Diff(2, info.Attempts, t)
+	testutil.Diff(clock.Now(), info.Time, t)
+
+	fixed = true
+	testutil.MustNoErr(natsutil.ReplayHandler(bus)(dead[0]), t)
+	testutil.Diff([]string{`{"id":"1"}`}, processed, t)
+	replayed := bus.Published("orders.created")
+	testutil.Diff("acme", replayed[len(replayed)-1].Header.Get("X-Tenant"), t)
+	testutil.Diff("", replayed[len(replayed)-1].Header.Get(natsutil.DeadLetterSubjectHeader), t)
+
+	err := natsutil.Replay(context.Background(), bus, replayed[0])
+	testutil.Diff(true, errors.Is(err, natsutil.ErrTerm), t)
+}
@@ -0,0 +1,239 @@
+package natsutil
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/nats-io/nats.go"
+)
+
+// route defines handler registered on the pattern
+type route struct {
+	pattern string
+	// tokens holds pattern tokens, named parameters are replaced with "*"
+	tokens  []string
+	params  map[int]string
+	handler MsgContextHandlerFunc
+}
+
+// parseRoute parses pattern with named single-token parameters, e.g. "orders.{id}.updated".
+// Pattern may also contain anonymous "*" tokens and trailing ">".
+func parseRoute(pattern string) (*route, error) {
+	r := &route{pattern: pattern, tokens: Tokens(strings.TrimPrefix(pattern, ".")), params: make(map[int]string)}
+	if len(r.tokens) == 0 {
+		return nil, fmt.Errorf("invalid route %q: empty pattern", pattern)
+	}
+	names := make(map[string]struct{})
+	for i, token := range r.tokens {
+		switch {
+		case token == "":
+			return nil, fmt.Errorf("invalid route %q: empty token", pattern)
+		case token == ">" && i != len(r.tokens)-1:
+			return nil, fmt.Errorf("invalid route %q: \">\" must be the last token", pattern)
+		case strings.HasPrefix(token, "{") && strings.HasSuffix(token, "}"):
+			name := token[1 : len(token)-1]
+			if name == "" || strings.ContainsAny(name, "{}*>") {
+				return nil, fmt.Errorf("invalid route %q: malformed parameter %q", pattern, token)
+			}
+			if _, ok := names[name]; ok {
+				return nil, fmt.Errorf("invalid route %q: duplicate parameter %q", pattern, name)
+			}
+			names[name] = struct{}{}
+			r.params[i] = name
+			r.tokens[i] = "*"
+		case strings.ContainsAny(token, "{}"):
+			return nil, fmt.Errorf("invalid route %q: malformed parameter %q", pattern, token)
+		}
+	}
+	return r, nil
+}
+
+// subject returns route's subscription subject, e.g. "orders.*.updated"
+func (r *route) subject() string {
+	subj := JoinSubject(r.tokens...)
+	if strings.HasPrefix(r.pattern, ".") {
+		return Abs(subj)
+	}
+	return subj
+}
+
+// specificity compares routes token by token: literal beats wildcard, wildcard beats trailing ">"
+func (r *route) specificity(other *route) int {
+	weight := func(token string) int {
+		switch token {
+		case ">":
+			return 0
+		case "*":
+			return 1
+		}
+		return 2
+	}
+	for i := 0; i < min(len(r.tokens), len(other.tokens)); i++ {
+		if c := cmp.Compare(weight(r.tokens[i]), weight(other.tokens[i])); c != 0 {
+			return c
+		}
+	}
+	return cmp.Compare(len(r.tokens), len(other.tokens))
+}
+
+// coversSubject checks whether every subject matching pattern b also matches pattern a
+func coversSubject(a string, b string) bool {
+	at, bt := Tokens(a), Tokens(b)
+	for i, token := range at {
+		if token == ">" {
+			return len(bt) > i
+		}
+		if i >= len(bt) || bt[i] == ">" || (token != "*" && (bt[i] == "*" || token != bt[i])) {
+			return false
+		}
+	}
+	return len(at) == len(bt)
+}
+
+type paramsCtxValue map[string]string
+
+// Params returns route parameters of the message handled by Router, nil if there are none
+func Params(ctx context.Context) map[string]string {
+	params, _ := ctx.Value(routeParamsKey).(paramsCtxValue)
+	return params
+}
+
+// Param returns value of the named route parameter of the message handled by Router, empty if it's missing
+func Param(ctx context.Context, name string) string {
+	return Params(ctx)[name]
+}
+
+// Router dispatches messages to handlers registered on patterns with named parameters, e.g. "orders.{id}.updated".
+// Message is handled by the most specific matching route only: literal token beats wildcard, wildcard beats ">".
+// Routes are subscribed with minimal set of subscriptions: subject covered by another route's one isn't subscribed.
+type Router struct {
+	mu         sync.Mutex
+	bus        Bus
+	routes     []*route
+	subscribed bool
+}
+
+// NewRouter returns new router on top of given bus
+func NewRouter(bus Bus) *Router {
+	return &Router{bus: bus}
+}
+
+// Handle registers handler on the given pattern, handler is wrapped with given middlewares.
+// Routes must be registered before router is subscribed.
+func (r *Router) Handle(pattern string, fn MsgContextHandlerFunc, mws ...Middleware) error {
+	rt, err := parseRoute(pattern)
+	if err != nil {
+		return err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.subscribed {
+		return fmt.Errorf("router is already subscribed, route=%q", pattern)
+	}
+	for _, other := range r.routes {
+		if slices.Equal(other.tokens, rt.tokens) && strings.HasPrefix(other.pattern, ".") == strings.HasPrefix(pattern, ".") {
+			return fmt.Errorf("route %q conflicts with %q", pattern, other.pattern)
+		}
+	}
+	rt.handler = Chain(fn, mws...)
+	r.routes = append(r.routes, rt)
+	return nil
+}
+
+// Subjects returns minimal set of subjects covering all routes
+func (r *Router) Subjects() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	subjs := make([]string, 0, len(r.routes))
+	for i, rt := range r.routes {
+		subj := rt.subject()
+		covered := false
+		for j, other := range r.routes {
+			if i == j || strings.HasPrefix(other.pattern, ".") != strings.HasPrefix(rt.pattern, ".") {
+				continue
+			}
+			if coversSubject(other.subject(), subj) {
+				covered = true
+				break
+			}
+		}
+		if !covered {
+			subjs = append(subjs, subj)
+		}
+	}
+	return subjs
+}
+
+// Subscribe subscribes router to its subjects, handlers are wrapped with given middlewares
+func (r *Router) Subscribe(mws ...Middleware) error {
+	return r.QueueSubscribe("", mws...)
+}
+
+// QueueSubscribe subscribes router to its subjects as a member of the queue group, handlers are wrapped with given middlewares
+func (r *Router) QueueSubscribe(queue string, mws ...Middleware) error {
+	subjs := r.Subjects()
+	r.mu.Lock()
+	if r.subscribed {
+		r.mu.Unlock()
+		return errors.New("router is already subscribed")
+	}
+	r.subscribed = true
+	r.mu.Unlock()
+	for _, subj := range subjs {
+		handler := r.dispatcher(subj)
+		var err error
+		if queue == "" {
+			err = r.bus.SubscribeContext(subj, handler, mws...)
+		} else {
+			err = r.bus.QueueSubscribeContext(subj, queue, handler, mws...)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// dispatcher returns handler of the subscription to the given subject.
+// Namespace prefix is derived from subscription's subject, so that routes are matched against relative subjects.
+func (r *Router) dispatcher(subj string) MsgContextHandlerFunc {
+	relTokens := len(Tokens(strings.TrimPrefix(subj, ".")))
+	abs := strings.HasPrefix(subj, ".")
+	return func(ctx context.Context, msg *nats.Msg) error {
+		tokens := Tokens(msg.Subject)
+		if msg.Sub != nil {
+			if prefix := len(Tokens(msg.Sub.Subject)) - relTokens; prefix > 0 && prefix <= len(tokens) {
+				tokens = tokens[prefix:]
+			}
+		}
+		rt := r.match(tokens, abs)
+		if rt == nil {
+			return fmt.Errorf("no route matches subj=%q", msg.Subject)
+		}
+		params := make(paramsCtxValue, len(rt.params))
+		for i, name := range rt.params {
+			params[name] = tokens[i]
+		}
+		return rt.handler(context.WithValue(ctx, routeParamsKey, params), msg)
+	}
+}
+
+// match returns the most specific route matching given subject tokens
+func (r *Router) match(tokens []string, abs bool) *route {
+	subj := JoinSubject(tokens...)
+	var best *route
+	for _, rt := range r.routes {
+		if strings.HasPrefix(rt.pattern, ".") != abs || !MatchSubject(JoinSubject(rt.tokens...), subj) {
+			continue
+		}
+		if best == nil || rt.specificity(best) > 0 {
+			best = rt
+		}
+	}
+	return best
+}
@@ -0,0 +1,74 @@
+package natsutil_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/nats-io/nats.go"
+
+	"github.com/avakarev/go-util/natsutil"
+	"github.com/avakarev/go-util/natsutil/natstest"
+	"github.com/avakarev/go-util/testutil"
+)
+
+func TestRouter(t *testing.T) {
+	bus := natstest.NewFakeBus("dev")
+	router := natsutil.NewRouter(bus)
+	got := make([]string, 0)
+	handler := func(name string) natsutil.MsgContextHandlerFunc {
+		return func(ctx context.Context, msg *nats.Msg) error {
+			got = append(got, name+" "+msg.Subject+" "+natsutil.Param(ctx, "id")+natsutil.Param(ctx, "item"))
+			return nil
+		}
+	}
+	testutil.MustNoErr(router.Handle("orders.{id}.updated", handler("updated")), t)
+	testutil.MustNoErr(router.Handle("orders.{id}.items.{item}", handler("item")), t)
+	testutil.MustNoErr(router.Handle("orders.vip.updated", handler("vip")), t)
+	testutil.MustNoErr(router.Handle("orders.>", handler("any")), t)
+	testutil.MustNoErr(router.Handle("invoices.{id}", handler("invoice")), t)
+
+	testutil.Diff([]string{"orders.>", "invoices.*"}, router.Subjects(), t)
+	testutil.MustNoErr(router.Subscribe(), t)
+
+	for _, subj := range []string{
+		"orders.1.updated",
+		"orders.vip.updated",
+		"orders.2.items.7",
+		"orders.3.deleted",
+		"invoices.4",
+		"invoices.4.paid",
+	} {
+		testutil.MustNoErr(bus.Publish(subj, nil), t)
+	}
+	testutil.Diff([]string{
+		"updated dev.orders.1.updated 1",
+		"vip dev.orders.vip.updated ",
+		"item dev.orders.2.items.7 27",
+		"any dev.orders.3.deleted ",
+		"invoice dev.invoices.4 4",
+	}, got, t)
+
+	testutil.MustErr(errors.New(`router is already subscribed, route="payments.{id}"`), router.Handle("payments.{id}", handler("payment")), t)
+}
+
+func TestRouterInvalidRoutes(t *testing.T) {
+	router := natsutil.NewRouter(natstest.NewFakeBus("dev"))
+	testutil.MustNoErr(router.Handle("orders.{id}.updated", nil), t)
+
+	cases := []struct {
+		pattern string
+		err     string
+	}{
+		{pattern: "", err: `invalid route "": empty pattern`},
+		{pattern: "orders..updated", err: `invalid route "orders..updated": empty token`},
+		{pattern: "orders.>.updated", err: `invalid route "orders.>.updated": ">" must be the last token`},
+		{pattern: "orders.{}", err: `invalid route "orders.{}": malformed parameter "{}"`},
+		{pattern: "orders.id{id}", err: `invalid route "orders.id{id}": malformed parameter "id{id}"`},
+		{pattern: "orders.{id}.{id}", err: `invalid route "orders.{id}.{id}": duplicate parameter "id"`},
+		{pattern: "orders.*.updated", err: `route "orders.*.updated" conflicts with "orders.{id}.updated"`},
+	}
+	for _, tt := range cases {
+		testutil.MustErr(errors.New(tt.err), router.Handle(tt.pattern, nil), t)
+	}
+}
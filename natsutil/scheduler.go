@@ -0,0 +1,341 @@
+package natsutil
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+	"github.com/rs/zerolog/log"
+
+	"github.com/avakarev/go-util/timeutil"
+)
+
+// SchedulesBucket defines name of the key-value bucket backing connection's scheduler
+const SchedulesBucket = "schedules"
+
+// ScheduleIDHeader carries id of the scheduled message it's published by
+const ScheduleIDHeader = "X-Schedule-Id"
+
+// ScheduledMsg defines persisted scheduled message
+type ScheduledMsg struct {
+	// ID identifies scheduled message, it's generated unless given; scheduling existing id replaces it
+	ID      string      `json:"id"`
+	Subject string      `json:"subject"`
+	Header  nats.Header `json:"header,omitempty"`
+	Data    []byte      `json:"data,omitempty"`
+	// At defines when message is published next time
+	At time.Time `json:"at"`
+	// Cron defines schedule of recurring message, see timeutil.ParseCron; it's empty for one-shot message
+	Cron string `json:"cron,omitempty"`
+	// LeaseUntil is set while scheduler instance publishes the message, so that others skip it
+	LeaseUntil time.Time `json:"leaseUntil,omitzero"`
+}
+
+// SchedulerConfig defines scheduler settings
+type SchedulerConfig struct {
+	// Interval defines how often due messages are checked, defaults to 1s
+	Interval time.Duration
+	// Lease defines how long message is claimed by the instance publishing it, defaults to 30s.
+	// If instance dies meanwhile, message is published by another one once lease expires.
+	Lease time.Duration
+	// Location defines time zone of cron schedules, defaults to UTC
+	Location *time.Location
+	// Clock defaults to wall clock
+	Clock timeutil.Clock
+}
+
+// Scheduler publishes delayed and recurring messages persisted in key-value bucket.
+// Multiple instances may run against the same bucket: every message is claimed by a single instance
+// with revision check, so that it's published once per occurrence unless publishing instance dies.
+// Messages are indexed in memory by bucket watcher, so that checking due ones doesn't read the whole bucket.
+type Scheduler struct {
+	kv     *KV[ScheduledMsg]
+	bus    Bus
+	config SchedulerConfig
+
+	mu      sync.Mutex // guards watcher and msgs
+	watcher jetstream.KeyWatcher
+	msgs    map[string]*Entry[ScheduledMsg]
+}
+
+// NewScheduler returns scheduler persisting messages in given bucket and publishing them to given bus
+func NewScheduler(bucket jetstream.KeyValue, bus Bus, config SchedulerConfig) *Scheduler {
+	if config.Interval <= 0 {
+		config.Interval = time.Second
+	}
+	if config.Lease <= 0 {
+		config.Lease = 30 * time.Second
+	}
+	if config.Location == nil {
+		config.Location = time.UTC
+	}
+	if config.Clock == nil {
+		config.Clock = timeutil.NewClock()
+	}
+	return &Scheduler{kv: WrapKV[ScheduledMsg](bucket), bus: bus, config: config}
+}
+
+// NewScheduler returns scheduler backed by SchedulesBucket, bucket is created unless it exists
+func (c *Conn) NewScheduler(ctx context.Context, config SchedulerConfig) (*Scheduler, error) {
+	kv, err := NewKV[ScheduledMsg](ctx, c, jetstream.KeyValueConfig{Bucket: SchedulesBucket})
+	if err != nil {
+		return nil, err
+	}
+	return NewScheduler(kv.Bucket(), c, config), nil
+}
+
+// Schedule persists given message and returns its id.
+// Recurring message without At is scheduled to the next occurrence of its cron schedule.
+func (s *Scheduler) Schedule(ctx context.Context, msg ScheduledMsg) (string, error) {
+	if msg.ID == "" {
+		msg.ID = uuid.NewString()
+	}
+	if msg.Cron != "" {
+		cron, err := timeutil.ParseCron(msg.Cron)
+		if err != nil {
+			return "", err
+		}
+		if msg.At.IsZero() {
+			msg.At = cron.Next(s.config.Clock.Now().In(s.config.Location))
+		}
+	}
+	if msg.At.IsZero() {
+		return "", fmt.Errorf("scheduled message has no time, id=%q", msg.ID)
+	}
+	msg.LeaseUntil = time.Time{}
+	if _, err := s.kv.Put(ctx, msg.ID, msg); err != nil {
+		return "", err
+	}
+	return msg.ID, nil
+}
+
+// PublishAt schedules publishing of given data to the given subject at given time
+func (s *Scheduler) PublishAt(ctx context.Context, at time.Time, subj string, data []byte) (string, error) {
+	return s.Schedule(ctx, ScheduledMsg{Subject: subj, Data: data, At: at})
+}
+
+// PublishIn schedules publishing of given data to the given subject after given delay
+func (s *Scheduler) PublishIn(ctx context.Context, delay time.Duration, subj string, data []byte) (string, error) {
+	return s.PublishAt(ctx, s.config.Clock.Now().Add(delay), subj, data)
+}
+
+// PublishCron schedules recurring publishing of given data to the given subject, see timeutil.ParseCron
+func (s *Scheduler) PublishCron(ctx context.Context, expr string, subj string, data []byte) (string, error) {
+	return s.Schedule(ctx, ScheduledMsg{Subject: subj, Data: data, Cron: expr})
+}
+
+// Get returns scheduled message by id, missing one is reported with jetstream.ErrKeyNotFound
+func (s *Scheduler) Get(ctx context.Context, id string) (*ScheduledMsg, error) {
+	e, err := s.kv.Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if e.Deleted() {
+		return nil, fmt.Errorf("%w, key=%q", jetstream.ErrKeyNotFound, id)
+	}
+	return &e.Value, nil
+}
+
+// Cancel cancels scheduled message by id, missing one is reported with jetstream.ErrKeyNotFound
+func (s *Scheduler) Cancel(ctx context.Context, id string) error {
+	if _, err := s.Get(ctx, id); err != nil {
+		return err
+	}
+	return s.kv.Delete(ctx, id)
+}
+
+// List returns all scheduled messages
+func (s *Scheduler) List(ctx context.Context) ([]ScheduledMsg, error) {
+	keys, err := s.kv.Keys(ctx)
+	if err != nil {
+		return nil, err
+	}
+	msgs := make([]ScheduledMsg, 0, len(keys))
+	for _, key := range keys {
+		msg, err := s.Get(ctx, key)
+		if errors.Is(err, jetstream.ErrKeyNotFound) { // cancelled meanwhile
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		msgs = append(msgs, *msg)
+	}
+	return msgs, nil
+}
+
+// fire claims given due message, publishes it and then deletes it or schedules its next occurrence.
+// It returns false if message was claimed by someone else.
+func (s *Scheduler) fire(ctx context.Context, e *Entry[ScheduledMsg]) (bool, error) {
+	msg := e.Value
+	now := s.config.Clock.Now()
+	msg.LeaseUntil = now.Add(s.config.Lease)
+	rev, err := s.kv.Update(ctx, msg.ID, msg, e.Revision)
+	if errors.Is(err, jetstream.ErrKeyExists) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	pub := nats.NewMsg(msg.Subject)
+	for k, v := range msg.Header {
+		pub.Header[k] = append([]string(nil), v...)
+	}
+	pub.Header.Set(ScheduleIDHeader, msg.ID)
+	pub.Data = msg.Data
+	if err := s.bus.PublishMsg(ctx, pub); err != nil {
+		return false, err
+	}
+
+	if msg.Cron != "" {
+		cron, err := timeutil.ParseCron(msg.Cron)
+		if err != nil {
+			return true, err
+		}
+		// missed occurrences, e.g. while no scheduler was running, are skipped
+		msg.At = cron.Next(now.In(s.config.Location))
+		msg.LeaseUntil = time.Time{}
+	}
+	if msg.Cron == "" || msg.At.IsZero() {
+		err = s.kv.Delete(ctx, msg.ID, jetstream.LastRevision(rev))
+	} else {
+		_, err = s.kv.Update(ctx, msg.ID, msg, rev)
+	}
+	// message was cancelled or rescheduled while being published
+	if errors.Is(err, jetstream.ErrKeyExists) {
+		return true, nil
+	}
+	return true, err
+}
+
+// apply applies given bucket update to the index of messages
+func (s *Scheduler) apply(e jetstream.KeyValueEntry) {
+	if e.Operation() != jetstream.KeyValuePut {
+		delete(s.msgs, e.Key())
+		return
+	}
+	entry, err := decodeEntry[ScheduledMsg](e)
+	if err != nil {
+		log.Error().Err(err).Msg("nats: scheduler index")
+		return
+	}
+	s.msgs[entry.Key] = entry
+}
+
+// sync applies pending bucket updates to the index of messages.
+// Bucket is watched on first call, which waits until index is filled with existing messages.
+func (s *Scheduler) sync(ctx context.Context) error {
+	if s.watcher == nil {
+		w, err := s.kv.Bucket().WatchAll(context.WithoutCancel(ctx))
+		if err != nil {
+			return err
+		}
+		s.watcher, s.msgs = w, make(map[string]*Entry[ScheduledMsg])
+		for done := false; !done; {
+			select {
+			case <-ctx.Done():
+				s.stop()
+				return ctx.Err()
+			case e, ok := <-w.Updates():
+				// nil entry marks that all initial values are delivered
+				done = !ok || e == nil
+				if !done {
+					s.apply(e)
+				}
+			}
+		}
+	}
+	for {
+		select {
+		case e, ok := <-s.watcher.Updates():
+			if !ok { // watcher is gone, bucket is watched again on next call
+				s.stop()
+				return nil
+			}
+			if e != nil {
+				s.apply(e)
+			}
+		default:
+			return nil
+		}
+	}
+}
+
+// due returns messages due at given time ordered by their time
+func (s *Scheduler) due(ctx context.Context, now time.Time) ([]*Entry[ScheduledMsg], error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.sync(ctx); err != nil {
+		return nil, err
+	}
+	due := make([]*Entry[ScheduledMsg], 0)
+	for _, e := range s.msgs {
+		if !e.Value.At.After(now) && !e.Value.LeaseUntil.After(now) {
+			due = append(due, e)
+		}
+	}
+	slices.SortFunc(due, func(a, b *Entry[ScheduledMsg]) int {
+		return a.Value.At.Compare(b.Value.At)
+	})
+	return due, nil
+}
+
+// Tick publishes all due messages once and returns number of published ones
+func (s *Scheduler) Tick(ctx context.Context) (int, error) {
+	now := s.config.Clock.Now()
+	due, err := s.due(ctx, now)
+	if err != nil {
+		return 0, err
+	}
+	fired := 0
+	errs := make([]error, 0)
+	for _, e := range due {
+		ok, err := s.fire(ctx, e)
+		if ok {
+			fired++
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%w, id=%q", err, e.Key))
+		}
+	}
+	return fired, errors.Join(errs...)
+}
+
+func (s *Scheduler) stop() {
+	if s.watcher == nil {
+		return
+	}
+	if err := s.watcher.Stop(); err != nil {
+		log.Error().Err(err).Msg("nats: scheduler watcher stop")
+	}
+	s.watcher, s.msgs = nil, nil
+}
+
+// Stop stops watching the bucket, it's watched again on next Tick
+func (s *Scheduler) Stop() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.stop()
+}
+
+// Run publishes due messages every interval until given context is done
+func (s *Scheduler) Run(ctx context.Context) {
+	defer s.Stop()
+	for {
+		if _, err := s.Tick(ctx); err != nil && ctx.Err() == nil {
+			log.Error().Err(err).Msg("nats: scheduler tick failed")
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-s.config.Clock.After(s.config.Interval):
+		}
+	}
+}
@@ -0,0 +1,211 @@
+package natsutil_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+
+	"github.com/avakarev/go-util/natsutil"
+	"github.com/avakarev/go-util/natsutil/natstest"
+	"github.com/avakarev/go-util/testutil"
+	"github.com/avakarev/go-util/timeutil"
+)
+
+func TestScheduler(t *testing.T) {
+	ctx := context.Background()
+	clock := timeutil.NewMock()
+	clock.Set(time.Date(2026, time.January, 15, 10, 30, 0, 0, time.UTC))
+	bucket := newMemBucket()
+	bus := natstest.NewFakeBus("dev")
+	scheduler := natsutil.NewScheduler(bucket, bus, natsutil.SchedulerConfig{Clock: clock})
+
+	reminder, err := scheduler.PublishIn(ctx, 10*time.Minute, "reminders.send", []byte("pay invoice"))
+	testutil.MustNoErr(err, t)
+	timeout, err := scheduler.PublishAt(ctx, clock.Now().Add(time.Hour), "orders.timeout", []byte("1"))
+	testutil.MustNoErr(err, t)
+	report, err := scheduler.Schedule(ctx, natsutil.ScheduledMsg{
+		ID:      "daily-report",
+		Subject: "reports.build",
+		Header:  nats.Header{"Content-Type": []string{"application/json"}},
+		Cron:    "0 9 * * *",
+	})
+	testutil.MustNoErr(err, t)
+	testutil.Diff("daily-report", report, t)
+
+	list, err := scheduler.List(ctx)
+	testutil.MustNoErr(err, t)
+	testutil.Diff(3, len(list), t)
+
+	n, err := scheduler.Tick(ctx)
+	testutil.MustNoErr(err, t)
+	testutil.Diff(0, n, t)
+
+	clock.Add(10 * time.Minute)
+	n, err = scheduler.Tick(ctx)
+	testutil.MustNoErr(err, t)
+	testutil.Diff(1, n, t)
+	msgs := bus.Published("reminders.send")
+	testutil.Diff(1, len(msgs), t)
+	testutil.Diff("pay invoice", string(msgs[0].Data), t)
+	testutil.Diff(reminder, msgs[0].Header.Get(natsutil.ScheduleIDHeader), t)
+	_, err = scheduler.Get(ctx, reminder)
+	testutil.Diff(true, errors.Is(err, jetstream.ErrKeyNotFound), t)
+
+	// fired once only
+	n, err = scheduler.Tick(ctx)
+	testutil.MustNoErr(err, t)
+	testutil.Diff(0, n, t)
+
+	testutil.MustNoErr(scheduler.Cancel(ctx, timeout), t)
+	testutil.Diff(true, errors.Is(scheduler.Cancel(ctx, timeout), jetstream.ErrKeyNotFound), t)
+
+	// recurring message is rescheduled, missed occurrences are skipped
+	clock.Add(48 * time.Hour)
+	n, err = scheduler.Tick(ctx)
+	testutil.MustNoErr(err, t)
+	testutil.Diff(1, n, t)
+	bus.MustNotPublished("orders.timeout", t)
+	msgs = bus.Published("reports.build")
+	testutil.Diff(1, len(msgs), t)
+	testutil.Diff("application/json", msgs[0].Header.Get("Content-Type"), t)
+	msg, err := scheduler.Get(ctx, report)
+	testutil.MustNoErr(err, t)
+	testutil.Diff(time.Date(2026, time.January, 18, 9, 0, 0, 0, time.UTC), msg.At, t)
+	testutil.Diff(true, msg.LeaseUntil.IsZero(), t)
+}
+
+func TestSchedulerAcrossInstances(t *testing.T) {
+	ctx := context.Background()
+	clock := timeutil.NewMock()
+	bucket := newMemBucket()
+	bus := natstest.NewFakeBus("dev")
+	config := natsutil.SchedulerConfig{Clock: clock, Lease: time.Minute}
+	a := natsutil.NewScheduler(bucket, bus, config)
+
+	_, err := a.PublishIn(ctx, time.Minute, "orders.timeout", nil)
+	testutil.MustNoErr(err, t)
+
+	// message persisted by one instance is fired by another one, e.g. after restart
+	clock.Add(time.Minute)
+	b := natsutil.NewScheduler(bucket, bus, config)
+	n, err := b.Tick(ctx)
+	testutil.MustNoErr(err, t)
+	testutil.Diff(1, n, t)
+	n, err = a.Tick(ctx)
+	testutil.MustNoErr(err, t)
+	testutil.Diff(0, n, t)
+	testutil.Diff(1, len(bus.Published("orders.timeout")), t)
+}
+
+func TestSchedulerInvalid(t *testing.T) {
+	scheduler := natsutil.NewScheduler(newMemBucket(), natstest.NewFakeBus("dev"), natsutil.SchedulerConfig{})
+	_, err := scheduler.PublishCron(context.Background(), "0 25 * * *", "reports.build", nil)
+	testutil.MustErr(errors.New(`invalid cron expression "0 25 * * *": hour value "25" is out of 0-23`), err, t)
+	_, err = scheduler.Schedule(context.Background(), natsutil.ScheduledMsg{ID: "1", Subject: "reports.build"})
+	testutil.MustErr(errors.New(`scheduled message has no time, id="1"`), err, t)
+}
+
+// indexedBucket fails reads of the whole bucket and of single keys
+type indexedBucket struct {
+	*memBucket
+}
+
+func (b indexedBucket) Keys(_ context.Context, _ ...jetstream.WatchOpt) ([]string, error) {
+	return nil, errors.New("bucket keys are listed")
+}
+
+func (b indexedBucket) Get(_ context.Context, key string) (jetstream.KeyValueEntry, error) {
+	return nil, errors.New("bucket key is read")
+}
+
+func TestSchedulerIndex(t *testing.T) {
+	ctx := context.Background()
+	clock := timeutil.NewMock()
+	bucket := newMemBucket()
+	bus := natstest.NewFakeBus("dev")
+	_, err := natsutil.NewScheduler(bucket, bus, natsutil.SchedulerConfig{Clock: clock}).PublishIn(ctx, time.Minute, "orders.timeout", nil)
+	testutil.MustNoErr(err, t)
+
+	// due messages are found in the index, both existing and scheduled later
+	scheduler := natsutil.NewScheduler(indexedBucket{bucket}, bus, natsutil.SchedulerConfig{Clock: clock})
+	defer scheduler.Stop()
+	n, err := scheduler.Tick(ctx)
+	testutil.MustNoErr(err, t)
+	testutil.Diff(0, n, t)
+	_, err = scheduler.PublishIn(ctx, 2*time.Minute, "orders.expire", nil)
+	testutil.MustNoErr(err, t)
+
+	clock.Add(2 * time.Minute)
+	n, err = scheduler.Tick(ctx)
+	testutil.MustNoErr(err, t)
+	testutil.Diff(2, n, t)
+	testutil.Diff(1, len(bus.Published("orders.timeout")), t)
+	testutil.Diff(1, len(bus.Published("orders.expire")), t)
+}
+
+func TestSchedulerRun(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	clock := timeutil.NewMock()
+	bus := natstest.NewFakeBus("dev")
+	scheduler := natsutil.NewScheduler(newMemBucket(), bus, natsutil.SchedulerConfig{Clock: clock, Interval: time.Minute})
+	_, err := scheduler.PublishIn(ctx, time.Minute, "orders.timeout", nil)
+	testutil.MustNoErr(err, t)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		scheduler.Run(ctx)
+	}()
+	// run is driven by the clock, not by wall time
+	deadline := time.Now().Add(time.Second)
+	for len(bus.Published("orders.timeout")) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("scheduled message is not published")
+		}
+		clock.Add(time.Minute)
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+	<-done
+}
+
+func TestConnScheduler(t *testing.T) {
+	ctx := context.Background()
+	s := runServer(t)
+	c := newConn(t, s)
+	clock := timeutil.NewMock()
+	scheduler, err := c.NewScheduler(ctx, natsutil.SchedulerConfig{Clock: clock})
+	testutil.MustNoErr(err, t)
+	defer scheduler.Stop()
+
+	published := make(chan *nats.Msg, 1)
+	testutil.MustNoErr(c.Subscribe("orders.timeout", func(msg *nats.Msg) error {
+		published <- msg
+		return nil
+	}), t)
+	n, err := scheduler.Tick(ctx)
+	testutil.MustNoErr(err, t)
+	testutil.Diff(0, n, t)
+
+	id, err := scheduler.PublishIn(ctx, time.Minute, "orders.timeout", []byte("1"))
+	testutil.MustNoErr(err, t)
+	clock.Add(time.Minute)
+	// index is updated by watcher asynchronously
+	deadline := time.Now().Add(time.Second)
+	for n == 0 && time.Now().Before(deadline) {
+		n, err = scheduler.Tick(ctx)
+		testutil.MustNoErr(err, t)
+		time.Sleep(10 * time.Millisecond)
+	}
+	testutil.Diff(1, n, t)
+	select {
+	case msg := <-published:
+		testutil.Diff(id, msg.Header.Get(natsutil.ScheduleIDHeader), t)
+	case <-time.After(time.Second):
+		t.Fatal("scheduled message is not published")
+	}
+}
@@ -0,0 +1,146 @@
+package natsutil
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/micro"
+	"github.com/rs/zerolog/log"
+
+	"github.com/avakarev/go-util/buildmeta"
+)
+
+var semverRegexp = regexp.MustCompile(`^\d+\.\d+\.\d+`)
+
+// ServiceConfig defines service settings
+type ServiceConfig struct {
+	// Name defines service name, it may contain only letters, digits, "-" and "_"
+	Name string
+	// Version defines service version, defaults to buildmeta.Ref if it's semver tag, "0.0.0" otherwise
+	Version     string
+	Description string
+	Metadata    map[string]string
+	// QueueGroup defines queue group of endpoints, defaults to micro.DefaultQueueGroup
+	QueueGroup string
+}
+
+// ServiceVersion returns service version derived from buildmeta.Ref, e.g. "1.2.3" for "v1.2.3" tag
+func ServiceVersion() string {
+	if v := strings.TrimPrefix(buildmeta.Ref, "v"); semverRegexp.MatchString(v) {
+		return v
+	}
+	return "0.0.0"
+}
+
+// Service groups request/reply endpoints under service name and version.
+// It's backed by nats micro service, which answers $SRV.PING, $SRV.INFO and $SRV.STATS discovery requests.
+type Service struct {
+	conn *Conn
+	svc  micro.Service
+	// binder binds endpoint requests to the connection, so that handlers can respond to them, see endpointHandler
+	binder *nats.Subscription
+}
+
+// NewService registers new micro service and subscribes to its discovery subjects
+func (c *Conn) NewService(config ServiceConfig) (*Service, error) {
+	if config.Version == "" {
+		config.Version = ServiceVersion()
+	}
+	svc, err := micro.AddService(c.conn, micro.Config{
+		Name:        config.Name,
+		Version:     config.Version,
+		Description: config.Description,
+		Metadata:    config.Metadata,
+		QueueGroup:  config.QueueGroup,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("%w, name=%q", err, config.Name)
+	}
+	binder, err := c.conn.SubscribeSync(c.conn.NewInbox())
+	if err != nil {
+		return nil, errors.Join(err, svc.Stop())
+	}
+	return &Service{conn: c, svc: svc, binder: binder}, nil
+}
+
+// ID returns service instance id
+func (s *Service) ID() string {
+	return s.svc.Info().ID
+}
+
+// endpointHandler adapts given handler and middlewares to micro handler.
+// Errors are replied via micro request, so that they're counted in endpoint stats.
+func (s *Service) endpointHandler(fn MsgContextHandlerFunc, mws []Middleware) micro.Handler {
+	fn = s.conn.chain(fn, mws)
+	return micro.HandlerFunc(func(req micro.Request) {
+		msg := &nats.Msg{
+			Subject: req.Subject(),
+			Reply:   req.Reply(),
+			Header:  nats.Header(req.Headers()),
+			Data:    req.Data(),
+			Sub:     s.binder,
+		}
+		ctx, cancel := s.conn.handlerCtx()
+		defer cancel()
+		err := fn(ContextFromHeader(ctx, msg.Header), msg)
+		if err == nil {
+			return
+		}
+		log.Error().Err(err).Str("subject", msg.Subject).Msg("nats: service endpoint")
+		reply, err := newErrReply(msg.Reply, err)
+		if err == nil {
+			err = req.Error(reply.Header.Get(ErrCodeHeader), reply.Header.Get(ErrHeader), reply.Data,
+				micro.WithHeaders(micro.Headers(reply.Header)))
+		}
+		if err != nil {
+			log.Error().Err(err).Str("subject", msg.Subject).Msg("nats: service endpoint reply")
+		}
+	})
+}
+
+// AddEndpoint subscribes given handler to the given subject within service's queue group.
+// Requests, errors and processing time are counted in service stats.
+func (s *Service) AddEndpoint(name string, subj string, fn MsgContextHandlerFunc, mws ...Middleware) error {
+	subj = s.conn.enrichSubj(subj)
+	if err := s.svc.AddEndpoint(name, s.endpointHandler(fn, mws), micro.WithEndpointSubject(subj)); err != nil {
+		return fmt.Errorf("%w, subj=%q", err, subj)
+	}
+	return nil
+}
+
+// AddEndpoint adds typed endpoint to the given service, see Handle
+func AddEndpoint[Req any, Resp any](s *Service, name string, subj string, fn HandlerFunc[Req, Resp], mws ...Middleware) error {
+	return s.AddEndpoint(name, subj, typedHandler(fn), mws...)
+}
+
+// Ping returns service ping response
+func (s *Service) Ping() micro.Ping {
+	return micro.Ping{ServiceIdentity: s.svc.Info().ServiceIdentity, Type: micro.PingResponseType}
+}
+
+// Info returns service info response
+func (s *Service) Info() micro.Info {
+	return s.svc.Info()
+}
+
+// Stats returns service stats response with per endpoint request and error counts and processing times
+func (s *Service) Stats() micro.Stats {
+	return s.svc.Stats()
+}
+
+// Reset resets service stats
+func (s *Service) Reset() {
+	s.svc.Reset()
+}
+
+// Stop unsubscribes service's endpoints and discovery subjects
+func (s *Service) Stop() error {
+	errs := []error{s.svc.Stop()}
+	if err := s.binder.Unsubscribe(); err != nil && !errors.Is(err, nats.ErrConnectionClosed) {
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
+}
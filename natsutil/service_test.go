@@ -0,0 +1,118 @@
+package natsutil_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/micro"
+
+	"github.com/avakarev/go-util/buildmeta"
+	"github.com/avakarev/go-util/natsutil"
+	"github.com/avakarev/go-util/testutil"
+)
+
+func TestServiceVersion(t *testing.T) {
+	ref := buildmeta.Ref
+	defer func() { buildmeta.Ref = ref }()
+
+	cases := []struct {
+		ref  string
+		want string
+	}{
+		{ref: "v1.2.3", want: "1.2.3"},
+		{ref: "1.2.3-rc.1", want: "1.2.3-rc.1"},
+		{ref: "main", want: "0.0.0"},
+		{ref: "", want: "0.0.0"},
+	}
+	for _, tt := range cases {
+		buildmeta.Ref = tt.ref
+		testutil.Diff(tt.want, natsutil.ServiceVersion(), t)
+	}
+}
+
+// discover sends service discovery request and decodes its reply
+func discover[T any](c *natsutil.Conn, subj string, t *testing.T) T {
+	t.Helper()
+	var resp T
+	testutil.MustNoErr(c.RequestJSONContext(context.Background(), subj, nil, &resp), t)
+	return resp
+}
+
+func TestService(t *testing.T) {
+	s := runServer(t)
+	c := newConn(t, s)
+	client := newConn(t, s)
+
+	_, err := c.NewService(natsutil.ServiceConfig{Name: "orders service"})
+	testutil.Diff(true, errors.Is(err, micro.ErrConfigValidation), t)
+
+	svc, err := c.NewService(natsutil.ServiceConfig{
+		Name:        "orders",
+		Version:     "1.2.3",
+		Description: "Manages orders",
+		Metadata:    map[string]string{"team": "core"},
+	})
+	testutil.MustNoErr(err, t)
+	testutil.MustNoErr(natsutil.AddEndpoint(svc, "create", "orders.create", incTotal), t)
+	testutil.MustNoErr(svc.AddEndpoint("slow", "orders.slow", func(_ context.Context, msg *nats.Msg) error {
+		time.Sleep(20 * time.Millisecond)
+		return natsutil.Respond(msg, nil)
+	}), t)
+
+	waitInterest(t, s, true, "$SRV.PING", "$SRV.PING.orders", "$SRV.PING.orders."+svc.ID(), "$SRV.INFO.orders",
+		"$SRV.STATS.orders", "$SRV.STATS.orders."+svc.ID(), "dev.orders.create", "dev.orders.slow")
+	identity := micro.ServiceIdentity{Name: "orders", ID: svc.ID(), Version: "1.2.3", Metadata: map[string]string{"team": "core"}}
+	for _, subj := range []string{"$SRV.PING", "$SRV.PING.orders", "$SRV.PING.orders." + svc.ID()} {
+		testutil.Diff(micro.Ping{ServiceIdentity: identity, Type: micro.PingResponseType}, discover[micro.Ping](client, subj, t), t)
+	}
+	testutil.Diff(micro.Info{
+		ServiceIdentity: identity,
+		Type:            micro.InfoResponseType,
+		Description:     "Manages orders",
+		Endpoints: []micro.EndpointInfo{
+			{Name: "create", Subject: "dev.orders.create", QueueGroup: "q"},
+			{Name: "slow", Subject: "dev.orders.slow", QueueGroup: "q"},
+		},
+	}, discover[micro.Info](client, "$SRV.INFO.orders", t), t)
+
+	errs := make([]string, 0)
+	for _, o := range []order{{ID: "1"}, {ID: "dup"}, {}} {
+		if _, err := natsutil.Call[order, order](client, "orders.create", o); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+	testutil.Diff([]string{"409: order exists", "400: validation error"}, errs, t)
+	for range 2 {
+		_, err := client.RequestContext(context.Background(), "orders.slow", nil)
+		testutil.MustNoErr(err, t)
+	}
+
+	stats := discover[micro.Stats](client, "$SRV.STATS.orders."+svc.ID(), t)
+	testutil.Diff(identity, stats.ServiceIdentity, t)
+	testutil.Diff(micro.StatsResponseType, stats.Type, t)
+	testutil.Diff(2, len(stats.Endpoints), t)
+	create, slow := stats.Endpoints[0], stats.Endpoints[1]
+	testutil.Diff([]any{"create", "dev.orders.create", 3, 2, "400:validation error"},
+		[]any{create.Name, create.Subject, create.NumRequests, create.NumErrors, create.LastError}, t)
+	testutil.Diff([]any{"slow", 2, 0}, []any{slow.Name, slow.NumRequests, slow.NumErrors}, t)
+	if slow.AverageProcessingTime < 20*time.Millisecond || slow.AverageProcessingTime != slow.ProcessingTime/2 {
+		t.Errorf("unexpected processing time of slow endpoint: total %s, average %s", slow.ProcessingTime, slow.AverageProcessingTime)
+	}
+
+	svc.Reset()
+	reset := discover[micro.Stats](client, "$SRV.STATS.orders", t)
+	testutil.Diff(true, reset.Started.After(stats.Started), t)
+	for _, e := range reset.Endpoints {
+		testutil.Diff([]any{0, 0, "", time.Duration(0), time.Duration(0)},
+			[]any{e.NumRequests, e.NumErrors, e.LastError, e.ProcessingTime, e.AverageProcessingTime}, t)
+	}
+
+	testutil.MustNoErr(svc.Stop(), t)
+	testutil.Diff(0, c.Health().Subscriptions, t)
+	waitInterest(t, s, false, "$SRV.PING.orders")
+	_, err = client.RequestContext(context.Background(), "$SRV.PING.orders", nil)
+	testutil.Diff(true, errors.Is(err, nats.ErrNoResponders), t)
+}
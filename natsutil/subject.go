@@ -0,0 +1,199 @@
+package natsutil
+
+import (
+	"slices"
+	"strings"
+
+	"github.com/avakarev/go-util/envutil"
+)
+
+// Template placeholders supported by TemplateNamer
+const (
+	PlaceholderEnv     = "{env}"
+	PlaceholderService = "{service}"
+	PlaceholderTenant  = "{tenant}"
+)
+
+// SubjectNamer defines namespacing of subjects and jetstream stream/bucket names.
+// Absolute subjects and names never reach namer, see NamespaceSubject.
+type SubjectNamer interface {
+	// Subject returns subject namespaced for the given env
+	Subject(env string, subj string) string
+	// Name returns stream/bucket name namespaced for the given env
+	Name(env string, name string) string
+}
+
+// DefaultNamer prefixes subjects with env, e.g. "dev.orders.created", and names with env, e.g. "dev_orders"
+var DefaultNamer SubjectNamer = &TemplateNamer{Template: PlaceholderEnv}
+
+// Abs marks given subject as absolute, so that it's used by connection as is, without namespacing
+func Abs(subj string) string {
+	return "." + subj
+}
+
+const inboxPrefix = "_INBOX."
+
+// absolute returns given subject without Abs mark and true if it's absolute:
+// explicitly marked one, system or inbox subject
+func absolute(subj string) (string, bool) {
+	switch {
+	case strings.HasPrefix(subj, "."):
+		return strings.TrimPrefix(subj, "."), true
+	case strings.HasPrefix(subj, "$"), strings.HasPrefix(subj, inboxPrefix):
+		return subj, true
+	}
+	return subj, false
+}
+
+// NamespaceSubject returns given subject namespaced by namer for the given env.
+// Absolute subject is returned as is, without Abs mark, and isn't passed to namer.
+func NamespaceSubject(namer SubjectNamer, env string, subj string) string {
+	if subj, ok := absolute(subj); ok {
+		return subj
+	}
+	return namer.Subject(env, subj)
+}
+
+// NamespaceName returns given stream/bucket name namespaced by namer for the given env,
+// name marked by Abs is returned as is and isn't passed to namer
+func NamespaceName(namer SubjectNamer, env string, name string) string {
+	if name, ok := absolute(name); ok {
+		return name
+	}
+	return namer.Name(env, name)
+}
+
+// TemplateNamer implements SubjectNamer prefixing subjects with template, e.g. "{env}.{service}.{tenant}".
+// Placeholders with empty values are omitted.
+// Subject which already starts with the prefix is not prefixed again; to keep cross-env requests working,
+// {env} matches any of known envs there.
+type TemplateNamer struct {
+	Template string
+	Service  string
+	Tenant   string
+	// Envs lists env tokens recognized in already prefixed subjects, defaults to dev, beta and prod
+	Envs []string
+}
+
+func (n *TemplateNamer) envs() []string {
+	if len(n.Envs) > 0 {
+		return n.Envs
+	}
+	return []string{envutil.EnvDev, envutil.EnvBeta, envutil.EnvProd}
+}
+
+// prefix returns template tokens expanded for the given env
+func (n *TemplateNamer) prefix(env string) []string {
+	tokens := make([]string, 0)
+	for _, t := range Tokens(n.Template) {
+		switch t {
+		case PlaceholderEnv:
+			t = env
+		case PlaceholderService:
+			t = n.Service
+		case PlaceholderTenant:
+			t = n.Tenant
+		}
+		if t != "" {
+			tokens = append(tokens, t)
+		}
+	}
+	return tokens
+}
+
+// Parse splits given subject into template values and the rest of subject.
+// It returns false if subject doesn't start with the namer's prefix.
+func (n *TemplateNamer) Parse(subj string) (*SubjectParts, bool) {
+	return n.parse(subj, n.envs())
+}
+
+func (n *TemplateNamer) parse(subj string, envs []string) (*SubjectParts, bool) {
+	parts := &SubjectParts{}
+	tokens := Tokens(subj)
+	i := 0
+	for _, t := range Tokens(n.Template) {
+		want := t
+		switch t {
+		case PlaceholderEnv:
+			want = ""
+		case PlaceholderService:
+			want = n.Service
+		case PlaceholderTenant:
+			want = n.Tenant
+		}
+		if t != PlaceholderEnv && want == "" {
+			continue
+		}
+		if i >= len(tokens) {
+			return nil, false
+		}
+		switch {
+		case t == PlaceholderEnv && slices.Contains(envs, tokens[i]):
+			parts.Env = tokens[i]
+		case t == PlaceholderService && tokens[i] == want:
+			parts.Service = want
+		case t == PlaceholderTenant && tokens[i] == want:
+			parts.Tenant = want
+		case tokens[i] != want || t == PlaceholderEnv:
+			return nil, false
+		}
+		i++
+	}
+	parts.Subject = JoinSubject(tokens[i:]...)
+	return parts, true
+}
+
+// Subject returns subject namespaced for the given env
+func (n *TemplateNamer) Subject(env string, subj string) string {
+	if _, ok := n.parse(subj, append(slices.Clone(n.envs()), env)); ok {
+		return subj
+	}
+	return JoinSubject(append(n.prefix(env), subj)...)
+}
+
+// Name returns stream/bucket name namespaced for the given env, e.g. "dev_orders"
+func (n *TemplateNamer) Name(env string, name string) string {
+	prefix := strings.Join(n.prefix(env), "_")
+	if prefix == "" || strings.HasPrefix(name, prefix+"_") {
+		return name
+	}
+	return prefix + "_" + name
+}
+
+// SubjectParts defines subject split into template values and the rest
+type SubjectParts struct {
+	Env     string
+	Service string
+	Tenant  string
+	Subject string
+}
+
+// Tokens splits given subject into tokens
+func Tokens(subj string) []string {
+	if subj == "" {
+		return []string{}
+	}
+	return strings.Split(subj, ".")
+}
+
+// JoinSubject joins given tokens into subject, empty tokens are skipped
+func JoinSubject(tokens ...string) string {
+	return strings.Join(slices.DeleteFunc(slices.Clone(tokens), func(t string) bool {
+		return t == ""
+	}), ".")
+}
+
+// MatchSubject checks whether given subject matches given pattern,
+// "*" matches exactly one token and trailing ">" matches one or more tokens
+func MatchSubject(pattern string, subj string) bool {
+	pt, st := Tokens(pattern), Tokens(subj)
+	for i, p := range pt {
+		if p == ">" && i == len(pt)-1 {
+			return len(st) > i
+		}
+		if i >= len(st) || (p != "*" && p != st[i]) {
+			return false
+		}
+	}
+	return len(pt) == len(st)
+}
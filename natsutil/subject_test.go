@@ -0,0 +1,103 @@
+package natsutil_test
+
+import (
+	"testing"
+
+	"github.com/avakarev/go-util/natsutil"
+	"github.com/avakarev/go-util/testutil"
+)
+
+func TestDefaultNamer(t *testing.T) {
+	cases := []struct {
+		subj string
+		want string
+	}{
+		{subj: "orders.created", want: "dev.orders.created"},
+		{subj: "dev.orders.created", want: "dev.orders.created"},
+		{subj: "prod.orders.created", want: "prod.orders.created"},
+		{subj: "production.orders", want: "dev.production.orders"},
+		{subj: "devices.x", want: "dev.devices.x"},
+		{subj: "orders.>", want: "dev.orders.>"},
+	}
+	for _, tt := range cases {
+		testutil.Diff(tt.want, natsutil.DefaultNamer.Subject("dev", tt.subj), t)
+	}
+	testutil.Diff("dev_orders", natsutil.DefaultNamer.Name("dev", "orders"), t)
+	testutil.Diff("dev_orders", natsutil.DefaultNamer.Name("dev", "dev_orders"), t)
+}
+
+// recordingNamer records subjects and names it's called with
+type recordingNamer struct {
+	calls []string
+}
+
+func (n *recordingNamer) Subject(env string, subj string) string {
+	n.calls = append(n.calls, subj)
+	return env + "." + subj
+}
+
+func (n *recordingNamer) Name(env string, name string) string {
+	n.calls = append(n.calls, name)
+	return env + "_" + name
+}
+
+func TestNamespaceSubject(t *testing.T) {
+	n := &recordingNamer{}
+	cases := []struct {
+		subj string
+		want string
+	}{
+		{subj: "orders.created", want: "dev.orders.created"},
+		{subj: natsutil.Abs("legacy.orders"), want: "legacy.orders"},
+		{subj: "$SRV.PING", want: "$SRV.PING"},
+		{subj: "_INBOX.abc", want: "_INBOX.abc"},
+	}
+	for _, tt := range cases {
+		testutil.Diff(tt.want, natsutil.NamespaceSubject(n, "dev", tt.subj), t)
+	}
+	testutil.Diff("dev_orders", natsutil.NamespaceName(n, "dev", "orders"), t)
+	testutil.Diff("devices", natsutil.NamespaceName(n, "dev", natsutil.Abs("devices")), t)
+	// absolute subjects and names never reach namer
+	testutil.Diff([]string{"orders.created", "orders"}, n.calls, t)
+}
+
+func TestTemplateNamer(t *testing.T) {
+	n := &natsutil.TemplateNamer{Template: "{env}.{service}.{tenant}", Service: "billing", Tenant: "acme"}
+	testutil.Diff("dev.billing.acme.invoices.paid", n.Subject("dev", "invoices.paid"), t)
+	testutil.Diff("prod.billing.acme.invoices.paid", n.Subject("dev", "prod.billing.acme.invoices.paid"), t)
+	testutil.Diff("dev.billing.acme.prod.invoices", n.Subject("dev", "prod.invoices"), t)
+	testutil.Diff("staging.billing.acme.x", n.Subject("staging", "staging.billing.acme.x"), t)
+	testutil.Diff("dev_billing_acme_invoices", n.Name("dev", "invoices"), t)
+
+	parts, ok := n.Parse("beta.billing.acme.invoices.*")
+	testutil.Diff(true, ok, t)
+	testutil.Diff(&natsutil.SubjectParts{Env: "beta", Service: "billing", Tenant: "acme", Subject: "invoices.*"}, parts, t)
+	_, ok = n.Parse("beta.shipping.acme.invoices")
+	testutil.Diff(false, ok, t)
+
+	// placeholders with empty values are omitted
+	n = &natsutil.TemplateNamer{Template: "{env}.{tenant}.{service}", Service: "billing"}
+	testutil.Diff("dev.billing.invoices", n.Subject("dev", "invoices"), t)
+}
+
+func TestMatchSubject(t *testing.T) {
+	cases := []struct {
+		pattern string
+		subj    string
+		want    bool
+	}{
+		{pattern: "dev.orders.created", subj: "dev.orders.created", want: true},
+		{pattern: "dev.orders.*", subj: "dev.orders.created", want: true},
+		{pattern: "dev.*.created", subj: "dev.orders.created", want: true},
+		{pattern: "dev.orders.*", subj: "dev.orders", want: false},
+		{pattern: "dev.orders.*", subj: "dev.orders.created.v1", want: false},
+		{pattern: "dev.>", subj: "dev.orders.created", want: true},
+		{pattern: "dev.orders.>", subj: "dev.orders", want: false},
+		{pattern: "dev", subj: "devices", want: false},
+	}
+	for _, tt := range cases {
+		testutil.Diff(tt.want, natsutil.MatchSubject(tt.pattern, tt.subj), t)
+	}
+	testutil.Diff("dev.orders.created", natsutil.JoinSubject("dev", "", "orders", "created"), t)
+	testutil.Diff([]string{"dev", "orders", "*"}, natsutil.Tokens("dev.orders.*"), t)
+}
@@ -0,0 +1,164 @@
+package natsutil
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/textproto"
+	"strings"
+
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// RequestIDHeader defines header carrying request id
+	RequestIDHeader = "X-Request-Id"
+	// TraceParentHeader defines W3C trace context header
+	TraceParentHeader = "traceparent"
+)
+
+type ctxKey int
+
+const (
+	requestIDKey ctxKey = iota
+	traceParentKey
+	routeParamsKey
+)
+
+// TraceParent defines W3C trace context, see https://www.w3.org/TR/trace-context/
+type TraceParent struct {
+	TraceID string
+	SpanID  string
+	Flags   string
+}
+
+// String returns traceparent header value
+func (tp TraceParent) String() string {
+	return fmt.Sprintf("00-%s-%s-%s", tp.TraceID, tp.SpanID, tp.Flags)
+}
+
+// Child returns trace context of the new span within the same trace
+func (tp TraceParent) Child() TraceParent {
+	return TraceParent{TraceID: tp.TraceID, SpanID: randHex(8), Flags: tp.Flags}
+}
+
+// NewTraceParent returns trace context of the new sampled trace
+func NewTraceParent() TraceParent {
+	return TraceParent{TraceID: randHex(16), SpanID: randHex(8), Flags: "01"}
+}
+
+// ParseTraceParent parses traceparent header value
+func ParseTraceParent(s string) (TraceParent, error) {
+	parts := strings.Split(strings.TrimSpace(s), "-")
+	if len(parts) < 4 || parts[0] == "ff" || (parts[0] == "00" && len(parts) != 4) {
+		return TraceParent{}, fmt.Errorf("invalid traceparent %q", s)
+	}
+	tp := TraceParent{TraceID: parts[1], SpanID: parts[2], Flags: parts[3]}
+	if !isHexID(parts[0], 1) || !isHexID(tp.TraceID, 16) || !isHexID(tp.SpanID, 8) || !isHexID(tp.Flags, 1) {
+		return TraceParent{}, fmt.Errorf("invalid traceparent %q", s)
+	}
+	return tp, nil
+}
+
+// isHexID checks whether given string is lowercase hex of given bytes length and not all zeros
+func isHexID(s string, n int) bool {
+	if len(s) != n*2 || strings.ToLower(s) != s {
+		return false
+	}
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		return false
+	}
+	if n == 1 {
+		return true
+	}
+	for _, c := range b {
+		if c != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func randHex(n int) string {
+	b := make([]byte, n)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// WithRequestID returns context carrying given request id
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey, id)
+}
+
+// RequestID returns request id carried by given context
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey).(string)
+	return id
+}
+
+// WithTraceParent returns context carrying given trace context
+func WithTraceParent(ctx context.Context, tp TraceParent) context.Context {
+	return context.WithValue(ctx, traceParentKey, tp)
+}
+
+// TraceParentFrom returns trace context carried by given context
+func TraceParentFrom(ctx context.Context) (TraceParent, bool) {
+	tp, ok := ctx.Value(traceParentKey).(TraceParent)
+	return tp, ok
+}
+
+// headerGet returns header value by exact key falling back to the canonical one,
+// so that it works with both case-sensitive nats.Header and canonicalized http.Header
+func headerGet(h map[string][]string, key string) string {
+	if v := h[key]; len(v) > 0 {
+		return v[0]
+	}
+	if v := h[textproto.CanonicalMIMEHeaderKey(key)]; len(v) > 0 {
+		return v[0]
+	}
+	return ""
+}
+
+// InjectHeader sets request id and traceparent headers from given context unless they're already set.
+// Header can be either nats.Header or http.Header.
+func InjectHeader(ctx context.Context, h map[string][]string) {
+	if id := RequestID(ctx); id != "" && headerGet(h, RequestIDHeader) == "" {
+		h[RequestIDHeader] = []string{id}
+	}
+	if tp, ok := TraceParentFrom(ctx); ok && headerGet(h, TraceParentHeader) == "" {
+		h[TraceParentHeader] = []string{tp.String()}
+	}
+}
+
+// ContextFromHeader returns context carrying request id and child span of the trace context from given header.
+// Context's zerolog logger is enriched with requestId and traceId fields, see zerolog.Ctx.
+// Header can be either nats.Header or http.Header.
+func ContextFromHeader(ctx context.Context, h map[string][]string) context.Context {
+	id := headerGet(h, RequestIDHeader)
+	tp, err := ParseTraceParent(headerGet(h, TraceParentHeader))
+	if id == "" && err != nil {
+		return ctx
+	}
+	logCtx := ctxLogger(ctx).With()
+	if id != "" {
+		ctx = WithRequestID(ctx, id)
+		logCtx = logCtx.Str("requestId", id)
+	}
+	if err == nil {
+		ctx = WithTraceParent(ctx, tp.Child())
+		logCtx = logCtx.Str("traceId", tp.TraceID)
+	}
+	logger := logCtx.Logger()
+	return logger.WithContext(ctx)
+}
+
+// ctxLogger returns logger of given context falling back to the global one
+func ctxLogger(ctx context.Context) *zerolog.Logger {
+	if l := zerolog.Ctx(ctx); l.GetLevel() != zerolog.Disabled {
+		return l
+	}
+	return &log.Logger
+}
@@ -0,0 +1,93 @@
+package natsutil_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/nats-io/nats.go"
+	"github.com/rs/zerolog"
+
+	"github.com/avakarev/go-util/natsutil"
+	"github.com/avakarev/go-util/testutil"
+)
+
+func TestParseTraceParent(t *testing.T) {
+	cases := []struct {
+		value string
+		want  natsutil.TraceParent
+		ok    bool
+	}{
+		{
+			value: "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+			want:  natsutil.TraceParent{TraceID: "4bf92f3577b34da6a3ce929d0e0e4736", SpanID: "00f067aa0ba902b7", Flags: "01"},
+			ok:    true,
+		},
+		{value: "00-00000000000000000000000000000000-00f067aa0ba902b7-01"},
+		{value: "00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01"},
+		{value: "00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01"},
+		{value: "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra"},
+		{value: "ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"},
+		{value: ""},
+	}
+	for _, tt := range cases {
+		got, err := natsutil.ParseTraceParent(tt.value)
+		testutil.Diff(tt.ok, err == nil, t)
+		testutil.Diff(tt.want, got, t)
+		if tt.ok {
+			testutil.Diff(tt.value, got.String(), t)
+		}
+	}
+}
+
+func TestPropagation(t *testing.T) {
+	tp := natsutil.NewTraceParent()
+	ctx := natsutil.WithTraceParent(natsutil.WithRequestID(context.Background(), "req-1"), tp)
+
+	// http.Header canonicalizes keys, nats.Header keeps them as is
+	for _, h := range []map[string][]string{http.Header{}, nats.Header{}} {
+		natsutil.InjectHeader(ctx, h)
+		testutil.Diff("req-1", h[natsutil.RequestIDHeader][0], t)
+		testutil.Diff(tp.String(), h[natsutil.TraceParentHeader][0], t)
+
+		got := natsutil.ContextFromHeader(context.Background(), h)
+		testutil.Diff("req-1", natsutil.RequestID(got), t)
+		child, ok := natsutil.TraceParentFrom(got)
+		testutil.Diff(true, ok, t)
+		testutil.Diff(tp.TraceID, child.TraceID, t)
+		testutil.Diff(true, child.SpanID != tp.SpanID, t)
+	}
+
+	h := http.Header{}
+	h.Set(natsutil.RequestIDHeader, "req-2")
+	h.Set(natsutil.TraceParentHeader, tp.String())
+	testutil.Diff("req-2", natsutil.RequestID(natsutil.ContextFromHeader(context.Background(), h)), t)
+
+	// explicitly set headers aren't overwritten
+	natsutil.InjectHeader(ctx, h)
+	testutil.Diff([]string{"req-2"}, h.Values(natsutil.RequestIDHeader), t)
+
+	empty := context.Background()
+	testutil.Diff(empty, natsutil.ContextFromHeader(empty, nats.Header{}), t)
+}
+
+func TestContextFromHeaderLogger(t *testing.T) {
+	var buf bytes.Buffer
+	ctx := zerolog.New(&buf).WithContext(context.Background())
+	h := nats.Header{}
+	h.Set(natsutil.RequestIDHeader, "req-1")
+	h.Set(natsutil.TraceParentHeader, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+
+	zerolog.Ctx(natsutil.ContextFromHeader(ctx, h)).Info().Msg("handled")
+
+	var entry map[string]string
+	testutil.MustNoErr(json.Unmarshal(buf.Bytes(), &entry), t)
+	testutil.Diff(map[string]string{
+		"level":     "info",
+		"requestId": "req-1",
+		"traceId":   "4bf92f3577b34da6a3ce929d0e0e4736",
+		"message":   "handled",
+	}, entry, t)
+}
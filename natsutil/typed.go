@@ -0,0 +1,56 @@
+package natsutil
+
+import (
+	"context"
+
+	"github.com/nats-io/nats.go"
+)
+
+// HandlerFunc defines typed request handler
+type HandlerFunc[Req any, Resp any] func(ctx context.Context, req Req) (Resp, error)
+
+// typedHandler adapts typed handler to message handler
+func typedHandler[Req any, Resp any](fn HandlerFunc[Req, Resp]) MsgContextHandlerFunc {
+	return func(ctx context.Context, msg *nats.Msg) error {
+		var req Req
+		if err := decodeValid(msg, &req); err != nil {
+			return err
+		}
+		resp, err := fn(ctx, req)
+		if err != nil {
+			return err
+		}
+		if msg.Reply == "" {
+			return nil
+		}
+		return RespondEncoded(msg, resp)
+	}
+}
+
+// Handle subscribes typed handler to the given subject.
+// Request is decoded with codec of its content type (JSON by default) and validated,
+// response is encoded with the same codec.
+// Malformed requests, validation errors and handler's errors are replied via bus's ErrHandler.
+// Handler is wrapped with bus's and given middlewares.
+func Handle[Req any, Resp any](conn Bus, subj string, fn HandlerFunc[Req, Resp], mws ...Middleware) error {
+	return conn.SubscribeContext(subj, typedHandler(fn), mws...)
+}
+
+// Call sends typed request and decodes reply into typed response.
+// Error reply is returned as *httputil.Err error.
+func Call[Req any, Resp any](conn Bus, subj string, req Req, opts ...ClientOption) (Resp, error) {
+	return CallContext[Req, Resp](context.Background(), conn, subj, req, opts...)
+}
+
+// CallContext is like Call but request is bound to the given context
+func CallContext[Req any, Resp any](ctx context.Context, conn Bus, subj string, req Req, opts ...ClientOption) (Resp, error) {
+	var resp Resp
+	msg, err := conn.RequestContext(ctx, subj, req, opts...)
+	if err != nil {
+		return resp, err
+	}
+	if err := DecodeMsg(msg, &resp); err != nil {
+		return resp, err
+	}
+	return resp, nil
+}
@@ -0,0 +1,124 @@
+package natsutil_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+
+	"github.com/avakarev/go-util/httputil"
+	"github.com/avakarev/go-util/natsutil"
+	"github.com/avakarev/go-util/natsutil/natstest"
+	"github.com/avakarev/go-util/testutil"
+)
+
+func incTotal(_ context.Context, o order) (order, error) {
+	if o.ID == "dup" {
+		return order{}, &httputil.NewErr(409, "order exists").Error
+	}
+	o.Total++
+	return o, nil
+}
+
+func TestHandle(t *testing.T) {
+	bus := natstest.NewFakeBus("dev")
+	calls := make([]string, 0)
+	testutil.MustNoErr(natsutil.Handle(bus, "orders.create", func(ctx context.Context, o order) (order, error) {
+		calls = append(calls, o.ID)
+		return incTotal(ctx, o)
+	}, func(next natsutil.MsgContextHandlerFunc) natsutil.MsgContextHandlerFunc {
+		return func(ctx context.Context, msg *nats.Msg) error {
+			calls = append(calls, "mw")
+			return next(ctx, msg)
+		}
+	}), t)
+
+	reply, err := bus.RequestContext(context.Background(), "orders.create", order{ID: "1", Total: 1})
+	testutil.MustNoErr(err, t)
+	testutil.Diff(`{"id":"1","total":2}`, string(reply.Data), t)
+
+	// published message is handled, but nothing is replied
+	testutil.MustNoErr(bus.PublishJSON("orders.create", order{ID: "2"}), t)
+
+	// malformed and invalid requests don't reach the handler
+	_, err = bus.RequestMsg(context.Background(), &nats.Msg{Subject: "orders.create", Data: []byte("{")})
+	var e *httputil.Err
+	testutil.Diff(true, errors.As(err, &e), t)
+	testutil.Diff(400, e.Code, t)
+	_, err = bus.RequestContext(context.Background(), "orders.create", order{})
+	testutil.Diff(true, errors.As(err, &e), t)
+	testutil.Diff(400, e.Code, t)
+	testutil.Diff("validation error", e.Msg, t)
+	testutil.Diff("id", e.Items[0].Subject, t)
+
+	testutil.Diff([]string{"mw", "1", "mw", "2", "mw", "mw"}, calls, t)
+}
+
+func TestCall(t *testing.T) {
+	bus := natstest.NewFakeBus("dev")
+	testutil.MustNoErr(natsutil.Handle(bus, "orders.create", incTotal), t)
+	testutil.MustNoErr(natsutil.Handle(bus, natsutil.Abs("prod.orders.create"), func(_ context.Context, o order) (order, error) {
+		o.Total = 100
+		return o, nil
+	}), t)
+
+	resp, err := natsutil.Call[order, order](bus, "orders.create", order{ID: "1", Total: 1})
+	testutil.MustNoErr(err, t)
+	testutil.Diff(order{ID: "1", Total: 2}, resp, t)
+
+	_, err = natsutil.Call[order, order](bus, "orders.create", order{ID: "dup"})
+	var e *httputil.Err
+	testutil.Diff(true, errors.As(err, &e), t)
+	testutil.Diff(409, e.Code, t)
+
+	// all client options are respected, not just timeout
+	resp, err = natsutil.Call[order, order](bus, "orders.create", order{ID: "1"}, natsutil.WithEnv("prod"))
+	testutil.MustNoErr(err, t)
+	testutil.Diff(order{ID: "1", Total: 100}, resp, t)
+	msg := bus.Published("orders.create")[0]
+	testutil.Diff("application/json", msg.Header.Get(natsutil.ContentTypeHeader), t)
+	bus.Reset()
+	resp, err = natsutil.Call[order, order](bus, "orders.create", order{ID: "1"}, natsutil.WithCodec(natsutil.MsgpackCodec))
+	testutil.MustNoErr(err, t)
+	testutil.Diff(order{ID: "1", Total: 1}, resp, t)
+	testutil.Diff("application/msgpack", bus.Published("orders.create")[0].Header.Get(natsutil.ContentTypeHeader), t)
+}
+
+func TestCallConn(t *testing.T) {
+	s := runServer(t)
+	server := newConn(t, s)
+	client := newConn(t, s, func(config *natsutil.ConnConfig) {
+		config.Codec = natsutil.MsgpackCodec
+	})
+	testutil.MustNoErr(natsutil.Handle(server, "orders.create", incTotal), t)
+	testutil.MustNoErr(server.Subscribe("orders.slow", func(msg *nats.Msg) error {
+		time.Sleep(50 * time.Millisecond)
+		return natsutil.RespondJSON(msg, order{ID: "slow"})
+	}), t)
+	waitInterest(t, s, true, "dev.orders.create", "dev.orders.slow")
+
+	resp, err := natsutil.Call[order, order](client, "orders.create", order{ID: "1", Total: 1})
+	testutil.MustNoErr(err, t)
+	testutil.Diff(order{ID: "1", Total: 2}, resp, t)
+
+	_, err = natsutil.Call[order, order](client, "orders.create", order{ID: "dup"})
+	var e *httputil.Err
+	testutil.Diff(true, errors.As(err, &e), t)
+	testutil.Diff(409, e.Code, t)
+
+	_, err = natsutil.Call[order, order](client, "orders.create", order{ID: "1"}, natsutil.WithEnv("prod"))
+	testutil.Diff(true, errors.Is(err, nats.ErrNoResponders), t)
+
+	_, err = natsutil.Call[order, order](client, "orders.slow", order{ID: "1"}, natsutil.WithTimeout(10*time.Millisecond))
+	testutil.Diff(true, errors.As(err, &e), t)
+	testutil.Diff(504, e.Code, t)
+
+	// context deadline takes precedence over client timeout
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	resp, err = natsutil.CallContext[order, order](ctx, client, "orders.slow", order{ID: "1"}, natsutil.WithTimeout(10*time.Millisecond))
+	testutil.MustNoErr(err, t)
+	testutil.Diff(order{ID: "slow"}, resp, t)
+}
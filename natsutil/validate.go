@@ -0,0 +1,104 @@
+package natsutil
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/nats-io/nats.go"
+
+	"github.com/avakarev/go-util/httputil"
+)
+
+// Validator defines validator of decoded payloads, e.g. *gormutil.DB
+type Validator interface {
+	Validate(v any) error
+}
+
+// structValidator implements Validator with go-playground validator, field names follow json tags
+type structValidator struct {
+	validate *validator.Validate
+}
+
+// Validate validates struct tags of given value, non-struct values are considered valid
+func (v *structValidator) Validate(value any) error {
+	err := v.validate.Struct(value)
+	var invalid *validator.InvalidValidationError
+	if errors.As(err, &invalid) { // not a struct, nothing to validate
+		return nil
+	}
+	return err
+}
+
+// NewValidator returns go-playground validator of struct tags configured same as gormutil.DB's one,
+// validation errors refer to json field names, see httputil.NewValidator
+func NewValidator() Validator {
+	return &structValidator{validate: httputil.NewValidator()}
+}
+
+var (
+	validatorMu sync.RWMutex
+	validate    = NewValidator()
+)
+
+// SetValidator replaces validator of decoded payloads, e.g. with *gormutil.DB to apply its custom validations
+func SetValidator(v Validator) {
+	validatorMu.Lock()
+	defer validatorMu.Unlock()
+	validate = v
+}
+
+func currentValidator() Validator {
+	validatorMu.RLock()
+	defer validatorMu.RUnlock()
+	return validate
+}
+
+// decodeValid decodes given message into given pointer destination and validates it.
+// Malformed payloads and validation errors are reported as *httputil.Err with 400 code,
+// validation error lists failed fields as items.
+func decodeValid(msg *nats.Msg, destPtr any) error {
+	codec, err := MsgCodec(msg)
+	if err != nil {
+		return err
+	}
+	if err := codec.Unmarshal(msg.Data, destPtr); err != nil {
+		return &httputil.NewErr(http.StatusBadRequest, "malformed payload: "+err.Error()).Error
+	}
+	if err := currentValidator().Validate(destPtr); err != nil {
+		var ve validator.ValidationErrors
+		if errors.As(err, &ve) {
+			return &httputil.NewValidationErr(ve).Error
+		}
+		return &httputil.NewErr(http.StatusBadRequest, err.Error()).Error
+	}
+	return nil
+}
+
+// ValidHandlerFunc defines handler of decoded and validated payload
+type ValidHandlerFunc[T any] func(ctx context.Context, msg *nats.Msg, v T) error
+
+func validHandler[T any](fn ValidHandlerFunc[T]) MsgContextHandlerFunc {
+	return func(ctx context.Context, msg *nats.Msg) error {
+		var v T
+		if err := decodeValid(msg, &v); err != nil {
+			return err
+		}
+		return fn(ctx, msg, v)
+	}
+}
+
+// SubscribeValid subscribes handler of decoded and validated payload to the given subject.
+// Payload is decoded with codec of its content type (JSON by default) and validated with current validator.
+// Invalid messages never reach the handler, their errors are passed to bus's ErrHandler,
+// which replies to requests with validation error items, see DefaultErrHandler.
+func SubscribeValid[T any](bus Bus, subj string, fn ValidHandlerFunc[T], mws ...Middleware) error {
+	return bus.SubscribeContext(subj, validHandler(fn), mws...)
+}
+
+// QueueSubscribeValid is like SubscribeValid but subscribes as a member of the queue group
+func QueueSubscribeValid[T any](bus Bus, subj string, queue string, fn ValidHandlerFunc[T], mws ...Middleware) error {
+	return bus.QueueSubscribeContext(subj, queue, validHandler(fn), mws...)
+}
@@ -0,0 +1,85 @@
+package natsutil_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/nats-io/nats.go"
+
+	"github.com/avakarev/go-util/gormutil"
+	"github.com/avakarev/go-util/httputil"
+	"github.com/avakarev/go-util/natsutil"
+	"github.com/avakarev/go-util/natsutil/natstest"
+	"github.com/avakarev/go-util/testutil"
+)
+
+var _ natsutil.Validator = (*gormutil.DB)(nil)
+
+type order struct {
+	ID    string `json:"id" validate:"required"`
+	Total int    `json:"total" validate:"gte=0"`
+}
+
+func TestSubscribeValid(t *testing.T) {
+	bus := natstest.NewFakeBus("dev")
+	var handlerErr error
+	bus.ErrHandler = func(msg *nats.Msg, err error) {
+		handlerErr = err
+		natsutil.DefaultErrHandler(msg, err)
+	}
+	got := make([]order, 0)
+	testutil.MustNoErr(natsutil.SubscribeValid(bus, "orders.create", func(ctx context.Context, msg *nats.Msg, o order) error {
+		got = append(got, o)
+		return natsutil.RespondJSON(msg, o)
+	}), t)
+
+	testutil.MustNoErr(bus.PublishJSON("orders.create", order{ID: "1", Total: 10}), t)
+	testutil.MustNoErr(bus.PublishJSON("orders.create", order{Total: -1}), t)
+	testutil.Diff([]order{{ID: "1", Total: 10}}, got, t)
+	testutil.Diff(&httputil.Err{
+		Code: 400,
+		Msg:  "validation error",
+		Items: []httputil.ValidationErr{
+			{Subject: "id", Msg: "required but missing"},
+			{Subject: "total", Msg: "invalid"},
+		},
+	}, handlerErr, t)
+
+	_, err := bus.RequestContext(context.Background(), "orders.create", order{Total: 1})
+	testutil.Diff(&httputil.Err{
+		Code:  400,
+		Msg:   "validation error",
+		Items: []httputil.ValidationErr{{Subject: "id", Msg: "required but missing"}},
+	}, err, t)
+
+	_, err = bus.RequestMsg(context.Background(), &nats.Msg{Subject: "orders.create", Data: []byte(`{"id":`)})
+	testutil.MustErr(errors.New("400: malformed payload: unexpected end of JSON input"), err, t)
+	testutil.Diff(1, len(got), t)
+}
+
+type validatorFunc func(v any) error
+
+func (fn validatorFunc) Validate(v any) error {
+	return fn(v)
+}
+
+func TestSetValidator(t *testing.T) {
+	natsutil.SetValidator(validatorFunc(func(v any) error {
+		if v.(*order).ID == "blocked" {
+			return errors.New("order is blocked")
+		}
+		return nil
+	}))
+	defer natsutil.SetValidator(natsutil.NewValidator())
+
+	bus := natstest.NewFakeBus("dev")
+	testutil.MustNoErr(natsutil.SubscribeValid(bus, "orders.create", func(ctx context.Context, msg *nats.Msg, o order) error {
+		return natsutil.RespondJSON(msg, o)
+	}), t)
+
+	_, err := bus.RequestContext(context.Background(), "orders.create", order{Total: -1})
+	testutil.MustNoErr(err, t)
+	_, err = bus.RequestContext(context.Background(), "orders.create", order{ID: "blocked"})
+	testutil.MustErr(errors.New("400: order is blocked"), err, t)
+}
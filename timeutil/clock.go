@@ -10,6 +10,7 @@ type Clock interface {
 	Now() time.Time
 	Since(t time.Time) time.Duration
 	Until(t time.Time) time.Duration
+	After(d time.Duration) <-chan time.Time
 }
 
 // clock adopts Clock to stdlib time
@@ -30,6 +31,11 @@ func (c *clock) Until(t time.Time) time.Duration {
 	return time.Until(t)
 }
 
+// After adopts time.After
+func (c *clock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+
 // NewClock returns an new clock value
 func NewClock() Clock {
 	return &clock{}
@@ -37,8 +43,15 @@ func NewClock() Clock {
 
 // Mock implements clock mock that can adjust time on demand
 type Mock struct {
-	mu  sync.Mutex
-	now time.Time
+	mu     sync.Mutex
+	now    time.Time
+	timers []mockTimer
+}
+
+// mockTimer defines channel waiting for the mock time to reach given point
+type mockTimer struct {
+	at time.Time
+	ch chan time.Time
 }
 
 // Now returns the current wall time on the mock clock
@@ -58,11 +71,35 @@ func (m *Mock) Until(t time.Time) time.Duration {
 	return t.Sub(m.Now())
 }
 
+// After returns channel receiving the current time once the mock clock is adjusted by given duration
+func (m *Mock) After(d time.Duration) <-chan time.Time {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	ch := make(chan time.Time, 1)
+	m.timers = append(m.timers, mockTimer{at: m.now.Add(d), ch: ch})
+	m.fire()
+	return ch
+}
+
+// fire sends the current time to timers which are due
+func (m *Mock) fire() {
+	pending := m.timers[:0]
+	for _, t := range m.timers {
+		if t.at.After(m.now) {
+			pending = append(pending, t)
+			continue
+		}
+		t.ch <- m.now
+	}
+	m.timers = pending
+}
+
 // Add adjusts the current time of the mock clock by given duration
 func (m *Mock) Add(d time.Duration) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 	m.now = m.now.Add(d)
+	m.fire()
 }
 
 // Set sets the current time of the mock clock to a given value
@@ -70,6 +107,7 @@ func (m *Mock) Set(t time.Time) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 	m.now = t
+	m.fire()
 }
 
 // NewMock returns new clock mock value
@@ -36,3 +36,17 @@ func TestMockUntil(t *testing.T) {
 	mock.Add(2 * time.Second)
 	testutil.Diff(float64(40), mock.Until(end).Seconds(), t)
 }
+
+func TestMockAfter(t *testing.T) {
+	mock := timeutil.NewMock()
+	ch := mock.After(42 * time.Second)
+	mock.Add(41 * time.Second)
+	select {
+	case <-ch:
+		t.Fatal("timer fired too early")
+	default:
+	}
+	mock.Add(time.Second)
+	testutil.Diff(time.Unix(42, 0), <-ch, t)
+	testutil.Diff(time.Unix(42, 0), <-mock.After(0), t)
+}
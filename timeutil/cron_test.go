@@ -0,0 +1,56 @@
+package timeutil_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/avakarev/go-util/timeutil"
+
+	"github.com/avakarev/go-util/testutil"
+)
+
+func TestCronScheduleNext(t *testing.T) {
+	// Thursday
+	from := time.Date(2026, time.January, 15, 10, 30, 45, 0, time.UTC)
+	cases := []struct {
+		expr string
+		want time.Time
+	}{
+		{expr: "* * * * *", want: time.Date(2026, time.January, 15, 10, 31, 0, 0, time.UTC)},
+		{expr: "*/15 * * * *", want: time.Date(2026, time.January, 15, 10, 45, 0, 0, time.UTC)},
+		{expr: "0 9-17 * * mon-fri", want: time.Date(2026, time.January, 15, 11, 0, 0, 0, time.UTC)},
+		{expr: "30 8 * * sat,sun", want: time.Date(2026, time.January, 17, 8, 30, 0, 0, time.UTC)},
+		{expr: "0 0 * * 7", want: time.Date(2026, time.January, 18, 0, 0, 0, 0, time.UTC)},
+		{expr: "0 0 1 */3 *", want: time.Date(2026, time.April, 1, 0, 0, 0, 0, time.UTC)},
+		{expr: "0 12 13 * fri", want: time.Date(2026, time.January, 16, 12, 0, 0, 0, time.UTC)},
+		{expr: "0 0 29 feb *", want: time.Date(2028, time.February, 29, 0, 0, 0, 0, time.UTC)},
+		{expr: "@daily", want: time.Date(2026, time.January, 16, 0, 0, 0, 0, time.UTC)},
+		{expr: "@monthly", want: time.Date(2026, time.February, 1, 0, 0, 0, 0, time.UTC)},
+		{expr: "0 0 30 2 *", want: time.Time{}},
+	}
+	for _, tt := range cases {
+		cron, err := timeutil.ParseCron(tt.expr)
+		testutil.MustNoErr(err, t)
+		testutil.Diff(tt.want, cron.Next(from), t)
+	}
+}
+
+func TestParseCronInvalid(t *testing.T) {
+	cases := []struct {
+		expr string
+		err  string
+	}{
+		{expr: "", err: `invalid cron expression "": expected 5 fields, got 0`},
+		{expr: "* * * *", err: `invalid cron expression "* * * *": expected 5 fields, got 4`},
+		{expr: "60 * * * *", err: `invalid cron expression "60 * * * *": minute value "60" is out of 0-59`},
+		{expr: "* * 0 * *", err: `invalid cron expression "* * 0 * *": day of month value "0" is out of 1-31`},
+		{expr: "* * * foo *", err: `invalid cron expression "* * * foo *": month value "foo" is out of 1-12`},
+		{expr: "*/0 * * * *", err: `invalid cron expression "*/0 * * * *": minute step "0" is invalid`},
+		{expr: "* 5-1 * * *", err: `invalid cron expression "* 5-1 * * *": hour range "5-1" is invalid`},
+	}
+	for _, tt := range cases {
+		_, err := timeutil.ParseCron(tt.expr)
+		testutil.MustErr(errors.New(tt.err), err, t)
+	}
+}